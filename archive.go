@@ -0,0 +1,221 @@
+package journal
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archivedSettings are the files, besides the encrypted entries, that Export
+// includes so that an imported journal works as the original did.
+var archivedSettings = []string{".gpgid", ".gpg-id", AgeRecipientsFile, ConfigFile, IgnoreFile}
+
+// Export writes the journal's encrypted files and recipient settings to a tar
+// archive at path, encrypting the whole archive too if encrypt is set.
+// Plaintext and the .check of an unlocked journal are never included.
+func (j *Journal) Export(path string, encrypt bool) error {
+	if j.dryRun {
+		fmt.Printf("Would export %d files to %s\n", len(j.Files), path)
+		return nil
+	}
+
+	// archive entries are named as in a locked journal
+	contents := make(map[string]string)
+	var names []string
+	for _, file := range j.Files {
+		names = append(names, j.relPath(file.enc))
+		contents[j.relPath(file.enc)] = file.encrypted()
+	}
+
+	err := filepath.Walk(j.RootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != j.RootDir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		for _, name := range archivedSettings {
+			if !info.IsDir() && info.Name() == name {
+				names = append(names, j.relPath(path))
+				contents[j.relPath(path)] = path
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading journal: %s", err)
+	}
+
+	tarPath := path
+	if encrypt {
+		tarPath = path + ".tmp"
+		defer os.Remove(tarPath)
+	}
+
+	out, err := os.OpenFile(tarPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("Error creating archive: %s", err)
+	}
+	if err := writeArchive(out, names, contents); err != nil {
+		out.Close()
+		os.Remove(tarPath)
+		return fmt.Errorf("Error writing archive %s: %s", tarPath, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Error writing archive %s: %s", tarPath, err)
+	}
+
+	if encrypt {
+		ctx, cancel := j.context()
+		defer cancel()
+		if err := j.crypter.Encrypt(ctx, tarPath, path); err != nil {
+			return fmt.Errorf("Error encrypting archive %s: %s", path, err)
+		}
+	}
+
+	fmt.Printf("Exported %d files to %s\n", len(names), path)
+	return nil
+}
+
+// writeArchive writes the files at contents[name] to w as a tar archive of
+// names.
+func writeArchive(w io.Writer, names []string, contents map[string]string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		path := contents[name]
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Import restores the journal archived by Export at path into dir, which
+// must not already hold any of its files. An encrypted archive is decrypted
+// with opts first.
+func Import(path, dir string, opts Options) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading archive: %s", err)
+	}
+
+	// an encrypted archive does not start with a tar header
+	if _, err := tar.NewReader(bytes.NewReader(content)).Next(); err != nil && err != io.EOF {
+		content, err = decryptArchive(path, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Error creating journal directory: %s", err)
+	}
+
+	count := 0
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading archive: %s", err)
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) || filepath.Clean(name) != name {
+			return fmt.Errorf("Error: archive entry %s is outside the journal", header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("Error: archive entry %s is not a regular file", header.Name)
+		}
+
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return fmt.Errorf("Error creating %s: %s", filepath.Dir(target), err)
+		}
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return fmt.Errorf("Error restoring %s: %s", name, err)
+		}
+		_, err = io.Copy(file, tr)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("Error restoring %s: %s", name, err)
+		}
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return fmt.Errorf("Error restoring %s: %s", name, err)
+		}
+		count++
+	}
+
+	fmt.Printf("Imported %d files into %s\n", count, dir)
+	return nil
+}
+
+// decryptArchive returns the decrypted contents of the encrypted archive at
+// path.
+func decryptArchive(path string, opts Options) ([]byte, error) {
+	if opts.GPGCommand == "" {
+		opts.GPGCommand = "gpg"
+	}
+	if opts.AgeCommand == "" {
+		opts.AgeCommand = "age"
+	}
+
+	var crypter Crypter = &GPGCrypter{
+		Command:        opts.GPGCommand,
+		Symmetric:      opts.Symmetric,
+		Passphrase:     opts.Passphrase,
+		PassphraseFile: opts.PassphraseFile,
+		Homedir:        opts.GPGHome,
+		Compress:       isSet(opts.Compress),
+		Retries:        opts.Retries,
+		Interactive:    opts.Interactive,
+		Verbose:        opts.Verbose,
+	}
+	if opts.Backend == "age" {
+		crypter = &AgeCrypter{Command: opts.AgeCommand, Identity: opts.AgeIdentity, Verbose: opts.Verbose}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
+	}
+	defer cancel()
+
+	var buf bytes.Buffer
+	if err := crypter.DecryptTo(ctx, path, &buf); err != nil {
+		return nil, fmt.Errorf("Error decrypting archive %s: %s", path, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package journal
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+		defer cleanup()
+		tj.write(t, ConfigFile, "editor = \"nano\"\n")
+
+		// an unlocked journal is exported as if it were locked
+		if err := tj.open(t, Options{}).Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.write(t, "a.txt", "a edited, not exported")
+
+		archive := filepath.Join(tj.fake.dir, "journal.tar")
+		if encrypt {
+			archive += ".gpg"
+		}
+		if err := tj.open(t, Options{}).Export(archive, encrypt); err != nil {
+			t.Fatalf("encrypt %v: %s", encrypt, err)
+		}
+		content, err := ioutil.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tar.NewReader(bytes.NewReader(content)).Next(); (err != nil) != encrypt {
+			t.Errorf("encrypt %v: got archive header error %v", encrypt, err)
+		}
+
+		dir := filepath.Join(tj.fake.dir, "imported")
+		if err := Import(archive, dir, Options{GPGCommand: tj.fake.command()}); err != nil {
+			t.Fatalf("encrypt %v: %s", encrypt, err)
+		}
+		imported := &testJournal{dir: dir, fake: tj.fake}
+		if got, want := imported.files(t), []string{".journal", "a.txt.gpg", "sub/b.txt.gpg"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("encrypt %v: imported %v, want %v", encrypt, got, want)
+		}
+		if !exists(imported.path(".gpgid")) {
+			t.Errorf("encrypt %v: .gpgid not imported", encrypt)
+		}
+		for name, footprint := range map[string]string{"a.txt.gpg": ".a.txt.gpg", "sub/b.txt.gpg": "sub/.b.txt.gpg"} {
+			if imported.read(t, name) != tj.read(t, footprint) {
+				t.Errorf("encrypt %v: imported %s differs from the encrypted file exported", encrypt, name)
+			}
+		}
+		if got := imported.decrypt(t, "a.txt.gpg"); got != "a" {
+			t.Errorf("encrypt %v: imported a.txt.gpg holds %q, want %q", encrypt, got, "a")
+		}
+
+		if err := Import(archive, dir, Options{GPGCommand: tj.fake.command()}); err == nil {
+			t.Errorf("encrypt %v: imported over an existing journal", encrypt)
+		}
+	}
+}
+
+func TestImportRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		header tar.Header
+		err    string
+	}{
+		{name: "parent", header: tar.Header{Name: "../escape.gpg", Typeflag: tar.TypeReg, Mode: 0600}, err: "outside the journal"},
+		{name: "absolute", header: tar.Header{Name: "/etc/escape.gpg", Typeflag: tar.TypeReg, Mode: 0600}, err: "outside the journal"},
+		{name: "unclean", header: tar.Header{Name: "sub/../../escape.gpg", Typeflag: tar.TypeReg, Mode: 0600}, err: "outside the journal"},
+		{name: "symlink", header: tar.Header{Name: "link.gpg", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}, err: "not a regular file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := tempJournal(t, nil)
+			defer cleanup()
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if err := tw.WriteHeader(&tt.header); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "journal.tar")
+			if err := ioutil.WriteFile(archive, buf.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Import(archive, filepath.Join(dir, "imported"), Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.err)
+			}
+			if exists(filepath.Join(dir, "escape.gpg")) {
+				t.Error("entry written outside the journal")
+			}
+		})
+	}
+}
+
+func TestExportRefusesExistingArchive(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	archive := filepath.Join(tj.fake.dir, "journal.tar")
+	if err := ioutil.WriteFile(archive, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{}).Export(archive, false); err == nil {
+		t.Error("exported over an existing file")
+	}
+	if content, _ := ioutil.ReadFile(archive); string(content) != "keep" {
+		t.Errorf("existing file overwritten with %q", content)
+	}
+	os.Remove(archive)
+}
@@ -0,0 +1,154 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// hiddenKeyID is the key id gpg records for a recipient hidden with
+// --throw-keyids.
+const hiddenKeyID = "0000000000000000"
+
+// Audit compares the keys each encrypted file is encrypted to with the
+// recipients the journal names for it in .gpgid, writing to w the files
+// missing a recipient or encrypted to a key of none, followed by a summary.
+// It returns an error if any file did not match. Nothing is decrypted.
+func (j *Journal) Audit(w io.Writer) error {
+	gpg, ok := j.crypter.(*GPGCrypter)
+	if !ok {
+		return fmt.Errorf("Error: audit is only supported by the gpg backend")
+	}
+	if gpg.Symmetric {
+		return fmt.Errorf("Error: a symmetric journal has no recipients to audit")
+	}
+
+	// the key ids of each recipient, primary and subkeys alike, as files
+	// are encrypted to one of its subkeys
+	keyIDs := make(map[string][]string)
+	recipientKeys := func(ctx context.Context, recipient string) ([]string, error) {
+		if ids, ok := keyIDs[recipient]; ok {
+			return ids, nil
+		}
+		ids, err := gpg.keyIDs(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+		keyIDs[recipient] = ids
+		return ids, nil
+	}
+
+	mismatches := 0
+	for _, file := range j.Files {
+		ctx, cancel := j.context()
+		problems, err := j.auditFile(ctx, gpg, file, recipientKeys)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintln(w, problem)
+		}
+		if len(problems) > 0 {
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("Audit failed: %d of %d files do not match their recipients", mismatches, len(j.Files))
+	}
+
+	fmt.Fprintf(w, "Audited journal: %d files are encrypted to their recipients only\n", len(j.Files))
+	return nil
+}
+
+// auditFile describes each mismatch between the keys file is encrypted to and
+// its recipients.
+func (j *Journal) auditFile(ctx context.Context, gpg *GPGCrypter, file FilePair, recipientKeys func(context.Context, string) ([]string, error)) ([]string, error) {
+	encryptedTo, err := gpg.EncryptedTo(ctx, file.encrypted())
+	if err != nil {
+		return nil, fmt.Errorf("Error reading recipients of %s: %s", file.encrypted(), err)
+	}
+	found := pathSet(encryptedTo)
+
+	recipients := gpg.recipientsFor(file.encrypted())
+	if gpg.EncryptToSelf {
+		self, err := gpg.selfRecipient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, self)
+	}
+
+	var problems []string
+	expected := make(map[string]bool)
+	for _, recipient := range recipients {
+		ids, err := recipientKeys(ctx, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("Error: %s", err)
+		}
+
+		present := false
+		for _, id := range ids {
+			expected[id] = true
+			present = present || found[id]
+		}
+		if !present {
+			problems = append(problems, fmt.Sprintf("MISSING %s: not encrypted to %s", j.relPath(file.enc), recipient))
+		}
+	}
+
+	for _, id := range encryptedTo {
+		switch {
+		case expected[id]:
+		case id == hiddenKeyID:
+			problems = append(problems, fmt.Sprintf("UNEXPECTED %s: encrypted to a hidden recipient", j.relPath(file.enc)))
+		default:
+			problems = append(problems, fmt.Sprintf("UNEXPECTED %s: encrypted to key %s", j.relPath(file.enc), id))
+		}
+	}
+
+	return problems, nil
+}
+
+// EncryptedTo returns the ids of the keys the file at path is encrypted to,
+// as gpg --list-only reports them, without decrypting it.
+func (g *GPGCrypter) EncryptedTo(ctx context.Context, path string) ([]string, error) {
+	status, err := g.runStatus(ctx, []string{"--batch", "--list-only", "-d", path}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, line := range status {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "ENC_TO" {
+			ids = append(ids, fields[1])
+		}
+	}
+	return ids, nil
+}
+
+// keyIDs returns the ids of the primary keys and subkeys of the public keys
+// matching recipient.
+func (g *GPGCrypter) keyIDs(ctx context.Context, recipient string) ([]string, error) {
+	var out bytes.Buffer
+	if err := g.run(ctx, []string{"--batch", "--with-colons", "--list-keys", recipient}, nil, &out); err != nil {
+		return nil, fmt.Errorf("no public key found for recipient %s: %s", recipient, err)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if (fields[0] == "pub" || fields[0] == "sub") && len(fields) > 4 {
+			ids = append(ids, fields[4])
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no public key found for recipient %s", recipient)
+	}
+	return ids, nil
+}
@@ -0,0 +1,61 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	me, two := defaultFakeKeys[0].keyID(), defaultFakeKeys[1].keyID()
+
+	tests := []struct {
+		name       string
+		recipients []string
+		want       string // empty if the file matches
+	}{
+		{name: "a.txt.gpg", recipients: []string{me}},
+		{name: "b.txt.gpg", recipients: []string{two}, want: "MISSING b.txt.gpg: not encrypted to me@example.com\nUNEXPECTED b.txt.gpg: encrypted to key " + two + "\n"},
+		{name: "c.txt.gpg", recipients: []string{me, two}, want: "UNEXPECTED c.txt.gpg: encrypted to key " + two + "\n"},
+		{name: "d.txt.gpg", recipients: []string{me, hiddenKeyID}, want: "UNEXPECTED d.txt.gpg: encrypted to a hidden recipient\n"},
+		// the nearest .gpgid names the recipients of each file
+		{name: "shared/e.txt.gpg", recipients: []string{two}},
+		{name: "shared/f.txt.gpg", recipients: []string{me}, want: "MISSING shared/f.txt.gpg: not encrypted to two@example.com\nUNEXPECTED shared/f.txt.gpg: encrypted to key " + me + "\n"},
+	}
+
+	tj, cleanup := newTestJournal(t, nil)
+	defer cleanup()
+	tj.write(t, "shared/.gpgid", "two@example.com\n")
+	var want string
+	mismatches := 0
+	for _, tt := range tests {
+		tj.fake.encrypt(t, tj.path(tt.name), tt.name, tt.recipients...)
+		want += tt.want
+		if tt.want != "" {
+			mismatches++
+		}
+	}
+
+	var out bytes.Buffer
+	err := tj.open(t, Options{}).Audit(&out)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d of %d files do not match", mismatches, len(tests))) {
+		t.Errorf("got error %v, want %d of %d files reported", err, mismatches, len(tests))
+	}
+	if out.String() != want {
+		t.Errorf("got report:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestAuditMatching(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+
+	var out bytes.Buffer
+	if err := tj.open(t, Options{}).Audit(&out); err != nil {
+		t.Fatalf("got error %v, output:\n%s", err, out.String())
+	}
+	if want := "Audited journal: 2 files are encrypted to their recipients only\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,23 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestBuild builds every package in the module, so that go test fails on a
+// tree that does not compile.
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out, err := exec.Command("go", "build", "-o", dir+string(os.PathSeparator), "./...").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build ./... failed: %s\n%s", err, out)
+	}
+}
@@ -2,40 +2,149 @@ package journal
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Checklist records the hash of each file in a directory. Paths are held
+// absolute but written relative to root, so a checklist remains valid when
+// the directory is moved.
 type Checklist struct {
-	files []struct {
-		path string
-		hash string
-	}
+	root  string
+	files []checklistFile
+}
+
+// checklistFile is a single checklist entry. mode and mtime are zero for
+// entries read from checklists that predate them.
+type checklistFile struct {
+	path  string
+	hash  string
+	mode  os.FileMode
+	mtime time.Time
 }
 
-func ChecklistFromReader(in io.Reader) (*Checklist, error) {
-	checklist := &Checklist{}
+// checklistHeader opens every checklist written by Write, naming the format
+// version and the hash algorithm of its entries.
+const checklistHeader = "# journal-checklist v2 sha256"
+
+// ChecklistFromReader reads a checklist from in, resolving relative paths
+// against root. Checklists without a header are read in the legacy format
+// of older versions.
+func ChecklistFromReader(in io.Reader, root string) (*Checklist, error) {
+	checklist := &Checklist{root: root}
 
 	r := bufio.NewReader(in)
-	for {
+	versioned := false
+	for n := 1; ; n++ {
 		line, _, err := r.ReadLine()
+		if err == io.EOF {
+			return checklist, nil
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		arr := strings.Split(string(line), " ")
-		checklist.AddFile(arr[1], arr[0])
+		// a checklist edited or synced on Windows ends its lines in \r\n
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if n == 1 && strings.HasPrefix(string(line), "# journal-checklist ") {
+			fields := strings.Fields(string(line))
+			if len(fields) != 4 || fields[2] != "v2" {
+				return nil, fmt.Errorf("unsupported checklist version on line %d: %s", n, line)
+			}
+			if fields[3] != "sha256" {
+				return nil, fmt.Errorf("unsupported checklist hash algorithm %s", fields[3])
+			}
+			versioned = true
+			continue
+		}
+
+		if versioned {
+			file, err := parseChecklistEntry(string(line), checklist)
+			if err != nil {
+				return nil, fmt.Errorf("malformed checklist entry on line %d: %s", n, err)
+			}
+			checklist.files = append(checklist.files, file)
+			continue
+		}
+
+		// legacy entries are "hash mode mtime path", or "hash path" in the
+		// oldest checklists. The path is the remainder of the line and may
+		// itself contain spaces.
+		arr := strings.SplitN(string(line), " ", 4)
+		if len(arr) == 4 {
+			mode, modeErr := strconv.ParseUint(arr[1], 8, 32)
+			mtime, mtimeErr := strconv.ParseInt(arr[2], 10, 64)
+			if modeErr == nil && mtimeErr == nil {
+				checklist.files = append(checklist.files, checklistFile{
+					path:  checklist.resolve(arr[3]),
+					hash:  arr[0],
+					mode:  os.FileMode(mode),
+					mtime: time.Unix(0, mtime),
+				})
+				continue
+			}
+		}
+
+		arr = strings.SplitN(string(line), " ", 2)
+		if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+			return nil, fmt.Errorf("malformed checklist entry on line %d", n)
+		}
+		checklist.AddFile(checklist.resolve(arr[1]), arr[0])
+	}
+}
+
+// parseChecklistEntry parses a v2 entry, "hash<TAB>mode<TAB>mtime<TAB>path",
+// where mode and mtime are "-" when not recorded.
+func parseChecklistEntry(line string, checklist *Checklist) (checklistFile, error) {
+	arr := strings.SplitN(line, "\t", 4)
+	if len(arr) != 4 || arr[0] == "" || arr[3] == "" {
+		return checklistFile{}, fmt.Errorf("expected 4 tab-separated fields")
+	}
+
+	file := checklistFile{path: checklist.resolve(arr[3]), hash: arr[0]}
+	if arr[1] != "-" {
+		mode, err := strconv.ParseUint(arr[1], 8, 32)
+		if err != nil {
+			return checklistFile{}, fmt.Errorf("invalid mode %s", arr[1])
+		}
+		file.mode = os.FileMode(mode)
+	}
+	if arr[2] != "-" {
+		mtime, err := strconv.ParseInt(arr[2], 10, 64)
+		if err != nil {
+			return checklistFile{}, fmt.Errorf("invalid mtime %s", arr[2])
+		}
+		file.mtime = time.Unix(0, mtime)
 	}
+
+	return file, nil
 }
 
+// ChecklistFromDir collects every file under dir accepted by filter.
+// Directories rejected by filter are not descended into.
 func ChecklistFromDir(dir string, filter func(path string, info os.FileInfo) bool) (*Checklist, error) {
-	checklist := &Checklist{}
+	return scanDir(dir, filter, true)
+}
+
+// scanDir lists the files under dir accepted by filter as ChecklistFromDir
+// does, though without hashing them unless hash is set.
+func scanDir(dir string, filter func(path string, info os.FileInfo) bool, hash bool) (*Checklist, error) {
+	checklist := &Checklist{root: dir}
 
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -43,6 +152,17 @@ func ChecklistFromDir(dir string, filter func(path string, info os.FileInfo) boo
 		}
 
 		if ok := filter(path, info); !ok {
+			if info.IsDir() && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+		if !hash {
+			checklist.files = append(checklist.files, checklistFile{path: path})
 			return nil
 		}
 
@@ -56,10 +176,79 @@ func ChecklistFromDir(dir string, filter func(path string, info os.FileInfo) boo
 }
 
 func (c *Checklist) AddFile(path, hash string) {
-	c.files = append(c.files, struct {
-		path string
-		hash string
-	}{path, hash})
+	c.files = append(c.files, checklistFile{path: path, hash: hash})
+}
+
+// Contains reports whether path is recorded in the checklist.
+func (c *Checklist) Contains(path string) bool {
+	for _, file := range c.files {
+		if file.path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hash returns the hash recorded for path.
+func (c *Checklist) hash(path string) (string, bool) {
+	for _, file := range c.files {
+		if file.path == path {
+			return file.hash, true
+		}
+	}
+
+	return "", false
+}
+
+// carryOver replaces the entries of c with those recorded in previous,
+// except for the paths in fresh.
+func (c *Checklist) carryOver(previous *Checklist, fresh map[string]bool) {
+	recorded := make(map[string]checklistFile, len(previous.files))
+	for _, file := range previous.files {
+		recorded[file.path] = file
+	}
+
+	for i, file := range c.files {
+		if old, ok := recorded[file.path]; ok && !fresh[file.path] {
+			c.files[i] = old
+		}
+	}
+}
+
+// recorded returns the set of paths in the checklist.
+func (c *Checklist) recorded() map[string]bool {
+	paths := make(map[string]bool, len(c.files))
+	for _, file := range c.files {
+		paths[file.path] = true
+	}
+
+	return paths
+}
+
+// Rename records the entry for from under to, reporting whether from was
+// recorded.
+func (c *Checklist) Rename(from, to string) bool {
+	for i := range c.files {
+		if c.files[i].path == from {
+			c.files[i].path = to
+			return true
+		}
+	}
+
+	return false
+}
+
+// Remove drops the entry for path, reporting whether it was recorded.
+func (c *Checklist) Remove(path string) bool {
+	for i := range c.files {
+		if c.files[i].path == path {
+			c.files = append(c.files[:i], c.files[i+1:]...)
+			return true
+		}
+	}
+
+	return false
 }
 
 func (c *Checklist) Collect(path string) error {
@@ -68,36 +257,98 @@ func (c *Checklist) Collect(path string) error {
 		return err
 	}
 
-	m := md5.New()
-	m.Write(content)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
 
-	hash := hex.EncodeToString(m.Sum(nil))
-	c.AddFile(path, hash)
+	c.files = append(c.files, checklistFile{
+		path:  path,
+		hash:  hashContent(sha256.New(), content),
+		mode:  info.Mode(),
+		mtime: info.ModTime(),
+	})
 
 	return nil
 }
 
-func (c *Checklist) Diff() (out []string, err error) {
+// Diff compares the recorded hashes against the files on disk, returning the
+// paths that have been modified and those that have been deleted.
+func (c *Checklist) Diff() (modified, deleted []string, err error) {
 	var content []byte
 	for _, file := range c.files {
 		content, err = ioutil.ReadFile(file.path)
+		if os.IsNotExist(err) {
+			deleted = append(deleted, file.path)
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		// checklists written before the switch to sha256 hold md5 hashes
+		h := sha256.New()
+		if len(file.hash) == hex.EncodedLen(md5.Size) {
+			h = md5.New()
+		}
+
+		if hashContent(h, content) != file.hash {
+			modified = append(modified, file.path)
+			continue
 		}
 
-		m := md5.New()
-		m.Write(content)
-		if hash := hex.EncodeToString(m.Sum(nil)); hash != file.hash {
-			out = append(out, file.path)
+		// a permission change alone must still reach the encrypted file
+		if file.mode != 0 {
+			info, err := os.Stat(file.path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if info.Mode() != file.mode {
+				modified = append(modified, file.path)
+			}
 		}
 	}
 
-	return out, nil
+	return modified, deleted, nil
 }
 
+// Added returns the paths of the files in current, a later checklist of the
+// same directory, that are not recorded in c.
+func (c *Checklist) Added(current *Checklist) []string {
+	recorded := c.recorded()
+	var added []string
+	for _, file := range current.files {
+		if !recorded[file.path] {
+			added = append(added, file.path)
+		}
+	}
+
+	return added
+}
+
+// Write writes the checklist in the v2 format: checklistHeader, then one
+// tab-separated entry per file as read by parseChecklistEntry.
 func (c *Checklist) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, checklistHeader+"\n"); err != nil {
+		return err
+	}
+
 	for _, file := range c.files {
-		_, err := io.WriteString(w, fmt.Sprintf("%s %s", file.hash, file.path))
+		path := file.path
+		if c.root != "" {
+			if rel, err := filepath.Rel(c.root, path); err == nil {
+				path = filepath.ToSlash(rel)
+			}
+		}
+
+		mode, mtime := "-", "-"
+		if file.mode != 0 {
+			mode = strconv.FormatUint(uint64(file.mode), 8)
+			mtime = strconv.FormatInt(file.mtime.UnixNano(), 10)
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\n", file.hash, mode, mtime, path)
+
+		_, err := io.WriteString(w, line)
 		if err != nil {
 			return err
 		}
@@ -105,3 +356,19 @@ func (c *Checklist) Write(w io.Writer) error {
 
 	return nil
 }
+
+// resolve returns path as recorded in a checklist file resolved against the
+// checklist root. Older checklists recorded absolute paths.
+func (c *Checklist) resolve(path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) || c.root == "" {
+		return path
+	}
+	return filepath.Join(c.root, path)
+}
+
+// hashContent returns the hex encoded digest of content using h.
+func hashContent(h hash.Hash, content []byte) string {
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,341 @@
+package journal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempJournal returns a temporary directory holding files, mapping relative
+// paths to their contents, and a function removing it.
+func tempJournal(t testing.TB, files map[string]string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "journal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the temporary directory may be reached through a symlink, as on macOS
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestChecklistFromReader(t *testing.T) {
+	root := filepath.FromSlash("/journal")
+	mtime := time.Unix(0, 1500000000000000000)
+
+	tests := []struct {
+		name  string
+		input string
+		want  []checklistFile
+		err   string
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "oldest legacy entries",
+			input: "abc /journal/a.txt\ndef sub/b.txt\n",
+			want: []checklistFile{
+				{path: filepath.FromSlash("/journal/a.txt"), hash: "abc"},
+				{path: filepath.Join(root, "sub", "b.txt"), hash: "def"},
+			},
+		},
+		{
+			name:  "legacy entries with mode and mtime",
+			input: "abc 600 1500000000000000000 a.txt\n",
+			want: []checklistFile{
+				{path: filepath.Join(root, "a.txt"), hash: "abc", mode: 0600, mtime: mtime},
+			},
+		},
+		{
+			name:  "legacy path with spaces",
+			input: "abc my diary.txt\nabc 600 1500000000000000000 my old diary.txt\n",
+			want: []checklistFile{
+				{path: filepath.Join(root, "my diary.txt"), hash: "abc"},
+				{path: filepath.Join(root, "my old diary.txt"), hash: "abc", mode: 0600, mtime: mtime},
+			},
+		},
+		{
+			name:  "legacy without trailing newline",
+			input: "abc a.txt",
+			want: []checklistFile{
+				{path: filepath.Join(root, "a.txt"), hash: "abc"},
+			},
+		},
+		{
+			name:  "v2",
+			input: checklistHeader + "\nabc\t600\t1500000000000000000\tsub/my diary.txt\ndef\t-\t-\tb.txt\n",
+			want: []checklistFile{
+				{path: filepath.Join(root, "sub", "my diary.txt"), hash: "abc", mode: 0600, mtime: mtime},
+				{path: filepath.Join(root, "b.txt"), hash: "def"},
+			},
+		},
+		{
+			name:  "crlf and blank lines",
+			input: checklistHeader + "\r\n\r\nabc\t-\t-\ta.txt\r\n\r\n",
+			want: []checklistFile{
+				{path: filepath.Join(root, "a.txt"), hash: "abc"},
+			},
+		},
+		{
+			name:  "legacy crlf",
+			input: "abc a.txt\r\n",
+			want: []checklistFile{
+				{path: filepath.Join(root, "a.txt"), hash: "abc"},
+			},
+		},
+		{
+			name:  "malformed legacy entry",
+			input: "abc a.txt\nnopath\n",
+			err:   "line 2",
+		},
+		{
+			name:  "malformed v2 entry",
+			input: checklistHeader + "\nabc a.txt\n",
+			err:   "line 2",
+		},
+		{
+			name:  "invalid v2 mode",
+			input: checklistHeader + "\nabc\tx\t-\ta.txt\n",
+			err:   "invalid mode",
+		},
+		{
+			name:  "unsupported version",
+			input: "# journal-checklist v3 sha256\n",
+			err:   "unsupported checklist version",
+		},
+		{
+			name:  "unsupported hash",
+			input: "# journal-checklist v2 sha1\n",
+			err:   "unsupported checklist hash algorithm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checklist, err := ChecklistFromReader(strings.NewReader(tt.input), root)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(checklist.files, tt.want) {
+				t.Errorf("got entries %v, want %v", checklist.files, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecklistWriteRoundTrip(t *testing.T) {
+	root := filepath.FromSlash("/journal")
+	mtime := time.Unix(0, 1500000000000000000)
+
+	tests := []struct {
+		name  string
+		files []checklistFile
+		want  string
+	}{
+		{
+			name: "empty",
+			want: checklistHeader + "\n",
+		},
+		{
+			name: "relative paths with spaces",
+			files: []checklistFile{
+				{path: filepath.Join(root, "sub", "my diary.txt"), hash: "abc", mode: 0640, mtime: mtime},
+				{path: filepath.Join(root, "b.txt"), hash: "def"},
+			},
+			want: checklistHeader + "\nabc\t640\t1500000000000000000\tsub/my diary.txt\ndef\t-\t-\tb.txt\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checklist := &Checklist{root: root, files: tt.files}
+			var buf bytes.Buffer
+			if err := checklist.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("wrote %q, want %q", buf.String(), tt.want)
+			}
+
+			read, err := ChecklistFromReader(&buf, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read.files, checklist.files) {
+				t.Errorf("read back %v, want %v", read.files, checklist.files)
+			}
+		})
+	}
+}
+
+func TestChecklistReadsMovedRoot(t *testing.T) {
+	checklist := &Checklist{root: filepath.FromSlash("/old"), files: []checklistFile{
+		{path: filepath.FromSlash("/old/a.txt"), hash: "abc"},
+	}}
+	var buf bytes.Buffer
+	if err := checklist.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := ChecklistFromReader(&buf, filepath.FromSlash("/new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moved.Contains(filepath.FromSlash("/new/a.txt")) {
+		t.Errorf("got entries %v, want /new/a.txt", moved.files)
+	}
+}
+
+func TestChecklistDiff(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{
+		"same.txt":     "same",
+		"changed.txt":  "before",
+		"md5.txt":      "old",
+		"md5-edit.txt": "old",
+		"mode.txt":     "mode",
+		"deleted.txt":  "gone",
+	})
+	defer cleanup()
+
+	checklist := &Checklist{root: dir}
+	for _, name := range []string{"same.txt", "changed.txt", "mode.txt", "deleted.txt"} {
+		if err := checklist.Collect(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// checklists written before sha256 hold md5 hashes
+	checklist.AddFile(filepath.Join(dir, "md5.txt"), hashContent(md5.New(), []byte("old")))
+	checklist.AddFile(filepath.Join(dir, "md5-edit.txt"), hashContent(md5.New(), []byte("old")))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "changed.txt"), []byte("after"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "md5-edit.txt"), []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "mode.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	modified, deleted, err := checklist.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(modified)
+	wantModified := []string{filepath.Join(dir, "changed.txt"), filepath.Join(dir, "md5-edit.txt"), filepath.Join(dir, "mode.txt")}
+	if !reflect.DeepEqual(modified, wantModified) {
+		t.Errorf("got modified %v, want %v", modified, wantModified)
+	}
+	wantDeleted := []string{filepath.Join(dir, "deleted.txt")}
+	if !reflect.DeepEqual(deleted, wantDeleted) {
+		t.Errorf("got deleted %v, want %v", deleted, wantDeleted)
+	}
+}
+
+func TestChecklistCollectHashesSHA256(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{"a.txt": "hello"})
+	defer cleanup()
+
+	checklist := &Checklist{root: dir}
+	path := filepath.Join(dir, "a.txt")
+	if err := checklist.Collect(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := hashContent(sha256.New(), []byte("hello"))
+	if got, _ := checklist.hash(path); got != want {
+		t.Errorf("got hash %s, want %s", got, want)
+	}
+}
+
+func TestChecklistAdded(t *testing.T) {
+	before := &Checklist{files: []checklistFile{{path: "a"}, {path: "b"}}}
+	after := &Checklist{files: []checklistFile{{path: "a"}, {path: "c"}, {path: "b"}, {path: "d"}}}
+
+	if got, want := before.Added(after), []string{"c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got added %v, want %v", got, want)
+	}
+	if got := after.Added(before); got != nil {
+		t.Errorf("got added %v, want none", got)
+	}
+}
+
+func TestChecklistRenameAndRemove(t *testing.T) {
+	checklist := &Checklist{files: []checklistFile{{path: "a", hash: "1"}, {path: "b", hash: "2"}}}
+
+	if !checklist.Rename("a", "c") || checklist.Rename("missing", "d") {
+		t.Fatal("Rename reported the wrong entries as recorded")
+	}
+	if hash, ok := checklist.hash("c"); !ok || hash != "1" || checklist.Contains("a") {
+		t.Errorf("got entries %v after renaming a to c", checklist.files)
+	}
+
+	if !checklist.Remove("b") || checklist.Remove("b") {
+		t.Fatal("Remove reported the wrong entries as recorded")
+	}
+	if want := []checklistFile{{path: "c", hash: "1"}}; !reflect.DeepEqual(checklist.files, want) {
+		t.Errorf("got entries %v, want %v", checklist.files, want)
+	}
+}
+
+func TestChecklistFromDirSkipsRejectedDirs(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{
+		"a.txt":        "a",
+		".hidden":      "h",
+		".git/config":  "g",
+		"sub/b.txt":    "b",
+		"sub/.c.txt":   "c",
+		".dot/d.txt":   "d",
+		"sub/deep/e.x": "e",
+	})
+	defer cleanup()
+
+	checklist, err := ChecklistFromDir(dir, func(path string, info os.FileInfo) bool {
+		return path == dir || !strings.HasPrefix(info.Name(), ".")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, file := range checklist.files {
+		got = append(got, file.path)
+	}
+	want := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b.txt"), filepath.Join(dir, "sub", "deep", "e.x")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
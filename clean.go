@@ -0,0 +1,171 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Clean reports the stale files that interrupted unlocks, locks and rekeys
+// can leave behind, removing them too if force is set:
+//
+//   - footprints (.foo.gpg) of entries that are locked again, so foo.gpg is
+//     back in place and its plaintext is neither present nor recorded in
+//     .check
+//   - the staging files lock and rekey encrypt to before moving them into
+//     place, and the .gpgid rekey stages beside them
+//
+// A footprint is only stale alongside its encrypted file, so a footprint
+// that is the last copy of an entry is never touched. A hidden entry
+// (.foo.gpg beside an entry foo.gpg) looks just like a footprint unless
+// EncChecklistFile records it, so force only removes a footprint that is
+// identical to its encrypted file, which loses nothing even if it was an
+// entry. One that differs is reported and left for the user.
+func (j *Journal) Clean(w io.Writer, force bool) error {
+	release, err := j.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var checklist *Checklist
+	if j.unlocked() {
+		if checklist, err = j.readChecklist(); err != nil {
+			return err
+		}
+	}
+
+	encChecklist, err := j.readEncChecklist()
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	reasons := make(map[string]string)
+	removable := make(map[string]bool)
+	err = filepath.Walk(j.RootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != j.RootDir && (j.topLevel || strings.HasPrefix(info.Name(), ".") || j.ignored(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !strings.HasPrefix(info.Name(), ".") || j.ignored(path) {
+			return nil
+		}
+		// hidden entries locked by the journal are recorded under their own
+		// path, which a footprint never is
+		if encChecklist.Contains(path) {
+			return nil
+		}
+
+		reason, ok, err := j.staleReason(path, checklist)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			stale = append(stale, path)
+			reasons[path] = reason
+			removable[path] = ok
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading journal: %s", err)
+	}
+
+	if len(stale) == 0 {
+		fmt.Fprintln(w, "No stale footprints found")
+		return nil
+	}
+
+	for _, path := range stale {
+		fmt.Fprintf(w, "STALE  %s: %s\n", j.relPath(path), reasons[path])
+	}
+	if !force {
+		fmt.Fprintf(w, "Found %d stale files. Run journal clean --force to remove them\n", len(stale))
+		return nil
+	}
+
+	removed := 0
+	for _, path := range stale {
+		if !removable[path] {
+			fmt.Fprintf(w, "Kept %s: it differs from its encrypted file, so check it by hand\n", j.relPath(path))
+			continue
+		}
+		if j.dryRun {
+			fmt.Fprintf(w, "Would remove %s\n", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("Error removing %s: %s", path, err)
+		}
+		removed++
+	}
+	if !j.dryRun {
+		fmt.Fprintf(w, "Removed %d stale files\n", removed)
+	}
+	return nil
+}
+
+// staleReason returns why the hidden file at path is stale, or "" if it is
+// not, and whether it is safe to remove: staging files always are, and a
+// footprint only when it is identical to its encrypted file.
+func (j *Journal) staleReason(path string, checklist *Checklist) (string, bool, error) {
+	name := strings.TrimPrefix(filepath.Base(path), ".")
+	dir := filepath.Dir(path)
+
+	for _, gpgid := range []string{".gpgid", ".gpg-id"} {
+		if filepath.Base(path) == filepath.Base(rekeyStaging(gpgid)) {
+			return "left by an interrupted rekey", true, nil
+		}
+	}
+
+	for _, staging := range []struct{ suffix, command string }{
+		{".tmp", "lock"},
+		{".rekey", "rekey"},
+	} {
+		if strings.HasSuffix(name, j.encryptedFileExt+staging.suffix) {
+			return "left by an interrupted " + staging.command, true, nil
+		}
+	}
+
+	if !strings.HasSuffix(name, j.encryptedFileExt) {
+		return "", false, nil
+	}
+	enc := filepath.Join(dir, name)
+	plain := j.plainPath(enc)
+	if !exists(enc) || exists(plain) || (checklist != nil && checklist.Contains(plain)) {
+		return "", false, nil
+	}
+
+	same, err := sameContent(path, enc)
+	if err != nil {
+		return "", false, err
+	}
+	if !same {
+		return fmt.Sprintf("footprint of %s, which is locked, but their contents differ", j.relPath(enc)), false, nil
+	}
+	return fmt.Sprintf("footprint of %s, which is locked", j.relPath(enc)), true, nil
+}
+
+// sameContent reports whether the files at a and b hold the same bytes.
+func sameContent(a, b string) (bool, error) {
+	contentA, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, fmt.Errorf("Error reading %s: %s", a, err)
+	}
+	contentB, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false, fmt.Errorf("Error reading %s: %s", b, err)
+	}
+
+	return bytes.Equal(contentA, contentB), nil
+}
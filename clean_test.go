@@ -0,0 +1,84 @@
+package journal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{
+		"a.txt":  "a",
+		"b.txt":  "b",
+		"c.txt":  "c",
+		"d.txt":  "d",
+		".d.txt": "a hidden entry",
+	})
+	defer cleanup()
+
+	// locking records the hidden entry .d.txt.gpg in .enc-check
+	if err := tj.open(t, Options{Only: []string{".d.txt"}}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	// a.txt is unlocked, so its footprint is in use
+	if err := tj.open(t, Options{Only: []string{"a.txt"}}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, ".b.txt.gpg", tj.read(t, "b.txt.gpg"))
+	tj.fake.encrypt(t, tj.path(".c.txt.gpg"), "c, older", defaultFakeKeys[0].keyID())
+	tj.write(t, ".e.txt.gpg.tmp", "staged")
+	tj.write(t, rekeyStaging(".gpgid"), "two@example.com\n")
+	tj.write(t, ".notes", "unrelated")
+	before := tj.snapshot(t)
+
+	var out bytes.Buffer
+	if err := tj.open(t, Options{}).Clean(&out, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "STALE  ..gpgid.rekey: left by an interrupted rekey\n" +
+		"STALE  .b.txt.gpg: footprint of b.txt.gpg, which is locked\n" +
+		"STALE  .c.txt.gpg: footprint of c.txt.gpg, which is locked, but their contents differ\n" +
+		"STALE  .e.txt.gpg.tmp: left by an interrupted lock\n" +
+		"Found 4 stale files. Run journal clean --force to remove them\n"
+	if out.String() != want {
+		t.Errorf("got report:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Error("clean without --force changed the journal")
+	}
+
+	out.Reset()
+	if err := tj.open(t, Options{}).Clean(&out, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Kept .c.txt.gpg") || !strings.HasSuffix(out.String(), "Removed 3 stale files\n") {
+		t.Errorf("got output:\n%s\nwant .c.txt.gpg kept and 3 files removed", out.String())
+	}
+	for _, name := range []string{".b.txt.gpg", ".e.txt.gpg.tmp", rekeyStaging(".gpgid")} {
+		if exists(tj.path(name)) {
+			t.Errorf("%s not removed", name)
+		}
+	}
+	for _, name := range []string{".a.txt.gpg", ".c.txt.gpg", ".d.txt.gpg", ".notes", ".gpgid", ".check", EncChecklistFile} {
+		if !exists(tj.path(name)) {
+			t.Errorf("%s removed", name)
+		}
+	}
+}
+
+func TestCleanNothingStale(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	var out bytes.Buffer
+	if err := tj.open(t, Options{}).Clean(&out, true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "No stale footprints found\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,927 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jmccnz/journal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	root = &cobra.Command{
+		Use:   "journal",
+		Short: "journal is an encryption helper for text files",
+		Run:   func(cmd *cobra.Command, args []string) {},
+		Long: `journal is an encryption helper for text files.
+
+Settings are taken from the command line flags first, then from the
+JOURNAL_GPG, JOURNAL_EXT, JOURNAL_RECIPIENT and JOURNAL_DIR environment
+variables, then from the journal's ` + journal.ConfigFile + ` file.`,
+	}
+	unlock = &cobra.Command{
+		Use:   "unlock [dir] [file or glob...]",
+		Short: "Open a directory of encrypted text files, or only the files given",
+		Run: func(cmd *cobra.Command, args []string) {
+			// a leading directory names the journal, and anything else the
+			// entries to unlock
+			dir, patterns := journalArgs(args)
+
+			rootDir, err := rootDirFromArgs(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, pattern := range patterns {
+				rel, err := journalRel(rootDir, pattern)
+				if err != nil {
+					log.Fatal(err)
+				}
+				unlockOnly = append(unlockOnly, rel)
+			}
+
+			j, err := newJournal(dir)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Unlock()
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	unlockForce bool
+	unlockKeep  bool
+	noFootprint bool
+	unlockSince string
+	outDir      string
+	unlockOnly  []string
+	keepGoing   bool
+
+	initialise = &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Initialise a directory for encrypted text files",
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := rootDirFromArgs(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			opts, err := options()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if autoRecipient && (len(recipients) > 0 || recipientFile != "" || symmetric) {
+				log.Fatal("Error: --auto-recipient cannot be used with --recipient, --recipient-file or --symmetric")
+			}
+			if !autoRecipient && !symmetric {
+				// the recipient file and config are read here so that the
+				// user's key is only a fallback
+				opts.Recipients, err = journal.InitRecipients(dir, opts)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			if autoRecipient || (len(opts.Recipients) == 0 && !symmetric) {
+				recipient, err := journal.SecretKeyRecipient(opts)
+				if err != nil && !autoRecipient {
+					// no single key to default to, so ask for one
+					fmt.Println(err)
+					recipient, err = promptRecipient()
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				opts.Recipients = []string{recipient}
+			}
+
+			err = journal.InitJournal(dir, opts, initForce)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	initForce     bool
+	autoRecipient bool
+
+	lockYes      bool
+	confirmAbove int
+
+	lock = &cobra.Command{
+		Use:   "lock [dir]",
+		Short: "Re-encrypt a directory of unlocked text files",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Lock()
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	status = &cobra.Command{
+		Use:   "status [dir]",
+		Short: "Show whether a directory is unlocked and which files changed",
+		Run: func(cmd *cobra.Command, args []string) {
+			if statusFormat == "default" {
+				statusFormat = journal.DefaultStatusFormat
+			}
+			format, err := template.New("status").Parse(statusFormat)
+			if err != nil {
+				log.Fatalf("Error: invalid --format template: %s", err)
+			}
+
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if statusFormat != "" {
+				var summary *journal.StatusSummary
+				summary, err = j.Summary()
+				if err == nil {
+					err = format.Execute(os.Stdout, summary)
+				}
+				if err == nil {
+					fmt.Println()
+				}
+			} else if statusJSON {
+				entries, err := j.StatusEntries()
+				if err != nil {
+					log.Fatal(err)
+				}
+				err = writeJSON(os.Stdout, entries)
+			} else {
+				err = j.Status()
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	statusJSON   bool
+	statusFormat string
+
+	edit = &cobra.Command{
+		Use:   "edit [file]",
+		Short: "Decrypt a single file, open it in $VISUAL or $EDITOR and re-encrypt it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Edit(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	session = &cobra.Command{
+		Use:   "session [dir]",
+		Short: "Unlock a journal, open it in $VISUAL or $EDITOR and lock it again when the editor exits",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Session()
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	check = &cobra.Command{
+		Use:   "check [dir]",
+		Short: "Look for plaintext or footprints left behind by an interrupted unlock or lock",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ok, err := j.Check(os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			fmt.Printf("Journal %s is consistent\n", j.RootDir)
+		},
+	}
+
+	export = &cobra.Command{
+		Use:   "export <archive> [dir]",
+		Short: "Write the encrypted files of a journal to a tar archive, for backups",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args[1:])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Export(args[0], exportEncrypt)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	exportEncrypt bool
+
+	importArchive = &cobra.Command{
+		Use:   "import <archive> [dir]",
+		Short: "Restore a journal from an archive written by export",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := rootDirFromArgs(args[1:])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			opts, err := options()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = journal.Import(args[0], dir, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cat = &cobra.Command{
+		Use:   "cat [file]",
+		Short: "Decrypt a single file to stdout without writing plaintext to disk",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Cat(args[0], os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	history = &cobra.Command{
+		Use:   "history <file>",
+		Short: "List the git commits of an entry, or decrypt it as of one of them with --show",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if historyRev != "" {
+				err = j.ShowRevision(args[0], historyRev, os.Stdout)
+			} else {
+				err = j.History(args[0], os.Stdout)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	historyRev string
+
+	move = &cobra.Command{
+		Use:   "move <old> <new>",
+		Short: "Rename an entry, whether the journal is locked or unlocked",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Move(args[0], args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	diff = &cobra.Command{
+		Use:   "diff [dir]",
+		Short: "List the unlocked files that have changed and would be re-encrypted",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			changes, err := j.Diff()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, changed := range changes {
+				fmt.Println(changed)
+			}
+		},
+	}
+
+	newEntry = &cobra.Command{
+		Use:   "new [name]",
+		Short: "Create an entry, named for today by default, and open it in $VISUAL or $EDITOR",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			err = j.New(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	add = &cobra.Command{
+		Use:   "add <file> [name]",
+		Short: "Encrypt an existing plaintext file into the journal as an entry, named as the file by default",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			name := filepath.Base(args[0])
+			if len(args) > 1 {
+				name = args[1]
+			}
+
+			err = j.Add(args[0], name, addKeepPlain)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	addKeepPlain bool
+
+	grep = &cobra.Command{
+		Use:   "grep <pattern> [dir]",
+		Short: "Search the contents of encrypted files without writing plaintext to disk",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			expr := args[0]
+			if grepIgnoreCase {
+				expr = "(?i)" + expr
+			}
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				log.Fatalf("Error: invalid pattern: %s", err)
+			}
+
+			j, err := newJournal(args[1:])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Grep(pattern, os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	grepIgnoreCase bool
+
+	list = &cobra.Command{
+		Use:   "list [dir]",
+		Short: "List the entries of a journal without decrypting them",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if listJSON {
+				entries, err := j.ListEntries()
+				if err != nil {
+					log.Fatal(err)
+				}
+				err = writeJSON(os.Stdout, entries)
+			} else {
+				err = j.List(os.Stdout, listLong)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	listLong bool
+	listJSON bool
+
+	verify = &cobra.Command{
+		Use:   "verify [dir]",
+		Short: "Check that every encrypted file can be decrypted, without writing plaintext to disk",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Verify(os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	verifyEnc = &cobra.Command{
+		Use:   "verify-enc [dir]",
+		Short: "Check the encrypted files against the hashes recorded when they were written, to find any changed outside the journal",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.VerifyEncrypted(os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	rekey = &cobra.Command{
+		Use:   "rekey [dir]",
+		Short: "Re-encrypt every entry to new recipients, or to those .gpgid lists now",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Rekey(rekeyTo)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	rekeyTo []string
+
+	clean = &cobra.Command{
+		Use:   "clean [dir]",
+		Short: "List stale footprints and staging files left by interrupted commands, removing them with --force",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Clean(os.Stdout, cleanForce)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cleanForce bool
+
+	audit = &cobra.Command{
+		Use:   "audit [dir]",
+		Short: "Check that every encrypted file is encrypted to the recipients in .gpgid and no others, without decrypting",
+		Run: func(cmd *cobra.Command, args []string) {
+			j, err := newJournal(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = j.Audit(os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	// flags shared by every command
+	gpgCommand       string
+	gpgHome          string
+	encryptedFileExt string
+	verbose          bool
+	symmetric        bool
+	passphraseFD     int
+	passphraseFile   string
+	armor            bool
+	compress         bool
+	shredPlain       bool
+	jobs             int
+	batchSize        int
+	dryRun           bool
+	backend          string
+	ageCommand       string
+	ageIdentity      string
+	gitCommit        bool
+	recipients       []string
+	ignorePatterns   []string
+	excludeExt       []string
+	timeout          time.Duration
+	retries          int
+	encryptToSelf    bool
+	tempDir          string
+	followSymlinks   bool
+	recursive        bool
+	recipientFile    string
+	plainExt         string
+	strictRecipients bool
+	interactive      bool
+)
+
+// environment variables consulted for settings not given as flags
+const (
+	envGPG       = "JOURNAL_GPG"
+	envExt       = "JOURNAL_EXT"
+	envRecipient = "JOURNAL_RECIPIENT" // comma separated gpg key ids
+	envDir       = "JOURNAL_DIR"
+)
+
+func init() {
+	root.PersistentFlags().StringVar(&gpgCommand, "gpg", "gpg", "gpg binary to invoke, in place of $"+envGPG+" and the journal's config")
+	root.PersistentFlags().StringVar(&gpgHome, "gpg-home", "", "GnuPG home directory to use in place of $GNUPGHOME")
+	root.PersistentFlags().StringVar(&encryptedFileExt, "ext", journal.DefaultFileExt, "extension of encrypted files, in place of $"+envExt+" and the journal's config")
+	root.PersistentFlags().StringVar(&plainExt, "plain-ext", "", "extension given to decrypted files that would have none, e.g. .md")
+	root.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "print each gpg command before running it")
+	root.PersistentFlags().BoolVar(&symmetric, "symmetric", false, "encrypt with a passphrase instead of gpg keys")
+	root.PersistentFlags().BoolVar(&armor, "armor", false, "write ASCII armored files, with a default extension of "+journal.ArmorFileExt)
+	root.PersistentFlags().BoolVar(&compress, "compress", false, "gzip plaintext before encrypting it with gpg, in place of gpg's compression")
+	root.PersistentFlags().BoolVar(&shredPlain, "shred", false, "overwrite plaintext with zeros before removing it on lock (best effort: copy-on-write and journaling filesystems may keep old blocks)")
+	root.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print what would be done without changing any files")
+	root.PersistentFlags().StringArrayVar(&recipients, "recipient", nil, "gpg key id to encrypt files to, in place of $"+envRecipient+", the journal's config and .gpgid (repeatable)")
+	root.PersistentFlags().BoolVar(&strictRecipients, "strict-recipients", false, "resolve each recipient to a single key fingerprint, failing if it matches none or several")
+	root.PersistentFlags().BoolVar(&interactive, "interactive", false, "let gpg prompt while decrypting, e.g. for a smartcard PIN or touch, by not passing --batch; gpg may then stop to ask on the terminal, so avoid it in scripts")
+	root.PersistentFlags().StringVar(&recipientFile, "recipient-file", "", "file listing gpg key ids like a .gpgid, used in place of .gpgid")
+	root.PersistentFlags().BoolVar(&encryptToSelf, "encrypt-to-self", false, "also encrypt every file to your own default secret key")
+	root.PersistentFlags().StringArrayVar(&ignorePatterns, "ignore", nil, "glob of files to leave out of the journal, in addition to "+journal.IgnoreFile+" (repeatable)")
+	root.PersistentFlags().StringArrayVar(&excludeExt, "exclude-ext", nil, "extension of files to leave out of the journal, e.g. .sig to skip foo.sig.gpg (repeatable)")
+	root.PersistentFlags().BoolVar(&followSymlinks, "follow-symlinks", false, "treat symlinked encrypted files as the files they point to within the journal (default skip them)")
+	root.PersistentFlags().BoolVar(&recursive, "recursive", true, "include entries in subdirectories; --recursive=false leaves them alone")
+	root.PersistentFlags().DurationVar(&timeout, "timeout", 0, "kill gpg if it runs longer than this on a single file, e.g. 30s (default no limit)")
+	root.PersistentFlags().StringVar(&tempDir, "tmpdir", "", "directory for the plaintext edit and new work on, e.g. a tmpfs (default "+os.TempDir()+")")
+	root.PersistentFlags().IntVar(&retries, "retries", 0, "retry gpg this many times when its agent fails transiently")
+	root.PersistentFlags().StringVar(&backend, "backend", "", "encryption backend, gpg or age (default: age if only "+journal.AgeRecipientsFile+" exists, otherwise gpg)")
+	root.PersistentFlags().StringVar(&ageCommand, "age", "age", "age binary to invoke")
+	root.PersistentFlags().StringVar(&ageIdentity, "age-identity", "", "age identity file used to decrypt")
+	root.PersistentFlags().IntVar(&passphraseFD, "passphrase-fd", -1, "read the symmetric passphrase from this file descriptor")
+	root.PersistentFlags().StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase of your secret key, or the symmetric passphrase, for use without pinentry")
+
+	unlock.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "number of files to decrypt concurrently")
+	unlock.Flags().IntVar(&batchSize, "batch-size", 1, "decrypt up to this many files with each gpg invocation, via gpg --decrypt-files; --timeout then limits each batch")
+	unlock.Flags().BoolVar(&unlockForce, "force", false, "decrypt again over the plaintext of an already unlocked journal, discarding edits")
+	unlock.Flags().BoolVar(&unlockKeep, "keep", false, "leave encrypted files in place instead of moving them to hidden footprints")
+	unlock.Flags().BoolVar(&noFootprint, "no-footprint", false, "delete encrypted files once decrypted; lock recreates them from the plaintext")
+	unlock.Flags().StringVar(&outDir, "out-dir", "", "decrypt into this directory, mirroring the journal, and leave the journal locked; the copy is read-only and cannot be locked")
+	unlock.Flags().BoolVar(&keepGoing, "keep-going", false, "carry on past files that fail to decrypt, exiting with 1 if any did")
+	unlock.Flags().StringVar(&unlockSince, "since", "", "only unlock entries dated on or after this date (YYYY-MM-DD), leaving older ones encrypted")
+
+	lock.Flags().BoolVar(&keepGoing, "keep-going", false, "carry on past files that fail to encrypt, leaving them unlocked and exiting with 1 if any did")
+	lock.Flags().BoolVarP(&lockYes, "yes", "y", false, "lock without asking, however many files changed")
+	lock.Flags().IntVar(&confirmAbove, "confirm-above", 20, "ask before locking more than this many modified or deleted files, 0 to never ask; without a terminal to ask on, lock then needs --yes")
+	lock.Flags().BoolVar(&gitCommit, "git", false, "commit the encrypted files when the journal is in a git work tree")
+
+	list.Flags().BoolVarP(&listLong, "long", "l", false, "also show modification times and footprints")
+	list.Flags().BoolVar(&listJSON, "json", false, "print the entries as a JSON array")
+
+	status.Flags().BoolVar(&statusJSON, "json", false, "print the entries and their changes as a JSON array")
+	status.Flags().StringVar(&statusFormat, "format", "", "print a summary with this text/template, given .Total, .Unlocked, .Modified, .New, .Deleted, .Locked and .Entries, or \"default\" for "+journal.DefaultStatusFormat)
+
+	history.Flags().StringVar(&historyRev, "show", "", "decrypt the entry as of this git revision to stdout")
+
+	add.Flags().BoolVar(&addKeepPlain, "keep-plain", false, "leave the original plaintext file in place")
+
+	grep.Flags().BoolVarP(&grepIgnoreCase, "ignore-case", "i", false, "match case insensitively")
+
+	export.Flags().BoolVar(&exportEncrypt, "encrypt", false, "encrypt the archive as a whole as well")
+	rekey.Flags().StringArrayVar(&rekeyTo, "to", nil, "gpg key id to re-encrypt entries to and write to .gpgid (repeatable)")
+
+	clean.Flags().BoolVar(&cleanForce, "force", false, "remove the stale files found")
+
+	initialise.Flags().BoolVar(&initForce, "force", false, "overwrite an existing .gpgid")
+	initialise.Flags().BoolVar(&autoRecipient, "auto-recipient", false, "encrypt to your only secret key, in place of any recipient from the environment or config")
+
+	root.AddCommand(initialise)
+	root.AddCommand(unlock)
+	root.AddCommand(lock)
+	root.AddCommand(status)
+	root.AddCommand(edit)
+	root.AddCommand(cat)
+	root.AddCommand(session)
+	root.AddCommand(move)
+	root.AddCommand(history)
+	root.AddCommand(diff)
+	root.AddCommand(newEntry)
+	root.AddCommand(add)
+	root.AddCommand(grep)
+	root.AddCommand(list)
+	root.AddCommand(verify)
+	root.AddCommand(verifyEnc)
+	root.AddCommand(audit)
+	root.AddCommand(check)
+	root.AddCommand(export)
+	root.AddCommand(importArchive)
+	root.AddCommand(rekey)
+	root.AddCommand(clean)
+}
+
+func main() {
+	if err := root.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// newJournal opens the journal named by args with the options given on the
+// command line.
+func newJournal(args []string) (*journal.Journal, error) {
+	dir, err := rootDirFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := options()
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := journal.Open(dir, opts)
+	if err == journal.ErrNotInitialised || err == journal.ErrNoAgeRecipients {
+		fmt.Println(err)
+		os.Exit(0)
+	}
+	return j, err
+}
+
+// options collects the journal options from the command line flags, or the
+// environment where a flag is not given. The gpg binary and extension are
+// left unset unless given either way, so that a journal's config file can
+// supply them.
+func options() (journal.Options, error) {
+	flags := root.PersistentFlags()
+	opts := journal.Options{
+		Backend:          backend,
+		GPGHome:          gpgHome,
+		AgeCommand:       ageCommand,
+		AgeIdentity:      ageIdentity,
+		Recipients:       recipients,
+		RecipientFile:    recipientFile,
+		StrictRecipients: strictRecipients,
+		Interactive:      interactive,
+		PlainExt:         plainExt,
+		EncryptToSelf:    encryptToSelf,
+		Symmetric:        symmetric,
+		PassphraseFile:   passphraseFile,
+		Ignore:           ignorePatterns,
+		ExcludeExt:       excludeExt,
+		Jobs:             jobs,
+		BatchSize:        batchSize,
+		Force:            unlockForce,
+		Keep:             unlockKeep,
+		NoFootprint:      noFootprint,
+		KeepGoing:        keepGoing,
+		ConfirmAbove:     confirmAbove,
+		Confirm:          confirmLock,
+		Only:             unlockOnly,
+		TempDir:          tempDir,
+		OutDir:           outDir,
+		FollowSymlinks:   followSymlinks,
+		TopLevel:         !recursive,
+		Shred:            shredPlain,
+		Git:              gitCommit,
+		DryRun:           dryRun,
+		Timeout:          timeout,
+		Retries:          retries,
+		Verbose:          verbose,
+	}
+	if flags.Changed("gpg") {
+		opts.GPGCommand = gpgCommand
+	} else {
+		opts.GPGCommand = os.Getenv(envGPG)
+	}
+	if flags.Changed("ext") {
+		opts.Ext = encryptedFileExt
+	} else {
+		opts.Ext = os.Getenv(envExt)
+	}
+	// Left unchanged, armor and compress fall back to the config, which
+	// --armor=false and --compress=false override.
+	if flags.Changed("armor") {
+		opts.Armor = &armor
+	}
+	if flags.Changed("compress") {
+		opts.Compress = &compress
+	}
+	if len(recipients) == 0 && recipientFile == "" {
+		opts.Recipients = envList(envRecipient)
+	}
+	if unlockSince != "" {
+		since, err := time.ParseInLocation("2006-01-02", unlockSince, time.Local)
+		if err != nil {
+			return opts, fmt.Errorf("Error: invalid --since date %q, expected YYYY-MM-DD", unlockSince)
+		}
+		opts.Since = since
+	}
+
+	// gpg consumes a passphrase fd, so read it once and hand it to each
+	// invocation. Without one gpg prompts through its agent.
+	if passphraseFD >= 0 && passphraseFile != "" {
+		return opts, fmt.Errorf("Error: --passphrase-fd and --passphrase-file cannot be used together")
+	}
+	if passphraseFD >= 0 && !symmetric {
+		return opts, fmt.Errorf("Error: --passphrase-fd is only used with --symmetric; pass --passphrase-file for the passphrase of a secret key")
+	}
+	if passphraseFD >= 0 {
+		line, err := bufio.NewReader(os.NewFile(uintptr(passphraseFD), "passphrase")).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return opts, fmt.Errorf("Error reading passphrase: %s", err)
+		}
+		opts.Passphrase = strings.TrimRight(line, "\r\n")
+	}
+
+	return opts, nil
+}
+
+// envList returns the comma separated values of the environment variable
+// name, if set.
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// rootDirFromArgs returns the journal directory named by args, defaulting to
+// $JOURNAL_DIR and then the current working directory.
+func rootDirFromArgs(args []string) (string, error) {
+	if len(args) == 0 && os.Getenv(envDir) != "" {
+		args = []string{os.Getenv(envDir)}
+	}
+	if len(args) == 0 {
+		dir, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("Error determining current directory: %s", err)
+		}
+		return dir, nil
+	}
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return "", fmt.Errorf("Error: %s is not a valid path: %s", args[0], err)
+	}
+	return dir, nil
+}
+
+// journalArgs splits args into the journal directory, if the first names
+// one, and the rest. A directory is only taken to be the journal if it holds
+// a journal's recipients or config, or lies outside the journal the command
+// would use otherwise, so that a subdirectory of entries can be given.
+func journalArgs(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+		return nil, args
+	}
+	if journal.IsJournal(args[0]) {
+		return args[:1], args[1:]
+	}
+
+	rootDir, err := rootDirFromArgs(nil)
+	if err != nil {
+		return args[:1], args[1:]
+	}
+	if abs, err := filepath.Abs(args[0]); err == nil && within(rootDir, abs) {
+		return nil, args
+	}
+	return args[:1], args[1:]
+}
+
+// journalRel returns the path of name relative to rootDir. A relative name
+// is taken relative to the current directory when that is within the
+// journal, and to rootDir otherwise.
+func journalRel(rootDir, name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", fmt.Errorf("Error: %s is not a valid path: %s", name, err)
+	}
+	if cwd, err := os.Getwd(); err == nil && !filepath.IsAbs(name) && !within(rootDir, cwd) {
+		abs = filepath.Join(rootDir, name)
+	}
+
+	if !within(rootDir, abs) {
+		return "", fmt.Errorf("Error: %s is outside the journal %s", name, rootDir)
+	}
+	return filepath.Rel(rootDir, abs)
+}
+
+// within reports whether path is dir or lies inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// confirmLock asks on stdin whether to lock the modified and deleted files,
+// showing a sample of them. It refuses to prompt when stdin is not a
+// terminal, unless --yes was given.
+func confirmLock(modified, deleted []string) error {
+	if lockYes {
+		return nil
+	}
+
+	if !stdinIsTerminal() {
+		return fmt.Errorf("Error: %d files modified and %d deleted. Pass --yes to lock them when stdin is not a terminal", len(modified), len(deleted))
+	}
+
+	fmt.Printf("%d files modified and %d deleted since unlock:\n", len(modified), len(deleted))
+	sample := append(append([]string(nil), modified...), deleted...)
+	for i, path := range sample {
+		if i == 10 {
+			fmt.Printf("  ... and %d more\n", len(sample)-i)
+			break
+		}
+		fmt.Printf("  %s\n", path)
+	}
+	fmt.Print("Lock them? [y/N] ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("Error reading answer: %s", err)
+	}
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		return fmt.Errorf("Lock cancelled; the journal is still unlocked")
+	}
+	return nil
+}
+
+// stdinIsTerminal reports whether stdin is a terminal someone can answer
+// prompts on.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	// cron and the like run commands on /dev/null, a character device too
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// promptRecipient asks for a gpg key id on stdin. It refuses to prompt when
+// stdin is not a terminal.
+func promptRecipient() (string, error) {
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("Error: no recipient given. Pass --recipient when stdin is not a terminal")
+	}
+
+	fmt.Print("GPG key id to encrypt to: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Error reading recipient: %s", err)
+	}
+
+	recipient := strings.TrimSpace(line)
+	if recipient == "" {
+		return "", fmt.Errorf("Error: no recipient given")
+	}
+	return recipient, nil
+}
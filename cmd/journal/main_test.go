@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmccnz/journal"
+)
+
+// setenv sets the environment variables in env, an empty value unsetting
+// one, and returns a function restoring them.
+func setenv(env map[string]string) func() {
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+// setFlag gives the persistent flag name as value, returning a function that
+// marks it as not given again. The variable it sets is left for the caller
+// to restore.
+func setFlag(t *testing.T, name, value string) func() {
+	t.Helper()
+
+	flag := root.PersistentFlags().Lookup(name)
+	if err := flag.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	flag.Changed = true
+
+	return func() { flag.Changed = false }
+}
+
+func TestOptionsFromEnvironment(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		flags      map[string]string
+		gpg, ext   string
+		recipients []string
+	}{
+		{
+			name: "unset, left to the config",
+			env:  map[string]string{envGPG: "", envExt: "", envRecipient: ""},
+		},
+		{
+			name:       "environment",
+			env:        map[string]string{envGPG: "/opt/gpg2", envExt: ".asc", envRecipient: "alice@example.com, bob@example.com,"},
+			gpg:        "/opt/gpg2",
+			ext:        ".asc",
+			recipients: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name:       "flags take precedence",
+			env:        map[string]string{envGPG: "/opt/gpg2", envExt: ".asc", envRecipient: "alice@example.com"},
+			flags:      map[string]string{"gpg": "gpg", "ext": ".pgp", "recipient": "carol@example.com"},
+			gpg:        "gpg",
+			ext:        ".pgp",
+			recipients: []string{"carol@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(tt.env)()
+			defer func(gpg, ext string, to []string) {
+				gpgCommand, encryptedFileExt, recipients = gpg, ext, to
+			}(gpgCommand, encryptedFileExt, recipients)
+			for name, value := range tt.flags {
+				defer setFlag(t, name, value)()
+			}
+
+			opts, err := options()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.GPGCommand != tt.gpg || opts.Ext != tt.ext || !reflect.DeepEqual(opts.Recipients, tt.recipients) {
+				t.Errorf("got gpg %q, ext %q and recipients %v, want %q, %q and %v", opts.GPGCommand, opts.Ext, opts.Recipients, tt.gpg, tt.ext, tt.recipients)
+			}
+		})
+	}
+}
+
+func TestRootDirFromEnvironment(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "journal")
+	defer setenv(map[string]string{envDir: dir})()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: dir},
+		{args: []string{filepath.Join(os.TempDir(), "other")}, want: filepath.Join(os.TempDir(), "other")},
+	}
+	for _, tt := range tests {
+		got, err := rootDirFromArgs(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("rootDirFromArgs(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+
+	os.Unsetenv(envDir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rootDirFromArgs(nil); err != nil || got != cwd {
+		t.Errorf("rootDirFromArgs(nil) = %s, %v, want the working directory %s", got, err, cwd)
+	}
+}
+
+func TestConfirmLockWithoutTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("y\n")
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	// an answer piped in is not taken for the user's
+	err = confirmLock([]string{"a.txt", "b.txt"}, []string{"c.txt"})
+	if err == nil || !strings.Contains(err.Error(), "2 files modified and 1 deleted. Pass --yes") {
+		t.Errorf("got error %v, want --yes asked for", err)
+	}
+
+	lockYes = true
+	defer func() { lockYes = false }()
+	if err := confirmLock([]string{"a.txt"}, nil); err != nil {
+		t.Errorf("got error %v with --yes, want none", err)
+	}
+}
+
+func TestLockAboveThresholdWithoutTerminal(t *testing.T) {
+	dir, _, cleanup := tempJournal(t)
+	defer cleanup()
+	defer setenv(map[string]string{envDir: ""})()
+
+	// an unlocked journal with more entries changed than --confirm-above
+	// allows by default
+	old := sha256.Sum256([]byte("old"))
+	checklist := "# journal-checklist v2 sha256\n"
+	for i := 0; i <= 20; i++ {
+		name := fmt.Sprintf("%02d.txt", i)
+		files := map[string]string{"." + name + ".gpg": "encrypted", name: "new"}
+		for file, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checklist += fmt.Sprintf("%x\t-\t-\t%s\n", old, name)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".check"), []byte(checklist), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		stdin io.Reader
+	}{
+		{name: "cron", stdin: nil}, // /dev/null
+		{name: "git hook", stdin: strings.NewReader("y\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runJournal(t, tt.stdin, "lock", "--symmetric", dir)
+			if err == nil || !strings.Contains(out, "21 files modified and 0 deleted. Pass --yes") {
+				t.Errorf("lock without a terminal printed %q, error %v, want it to fail asking for --yes", out, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".check")); err != nil {
+				t.Errorf("got error %v, want the journal left unlocked", err)
+			}
+		})
+	}
+}
+
+// mainProcessEnv has the test binary run as the journal command, for tests
+// of commands that exit the process.
+const mainProcessEnv = "JOURNAL_TEST_MAIN"
+
+// runJournal runs the journal command with args in a process of its own,
+// reading stdin, or /dev/null if nil, and returns its combined output and
+// exit error.
+func runJournal(t *testing.T, stdin io.Reader, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, args...)...)
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), mainProcessEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// TestMainProcess runs the journal command given by the arguments after --
+// when started by runJournal.
+func TestMainProcess(t *testing.T) {
+	if os.Getenv(mainProcessEnv) == "" {
+		return
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	root.SetArgs(args)
+	if err := root.Execute(); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// chdir changes the working directory to dir, returning a function changing
+// it back.
+func chdir(t *testing.T, dir string) func() {
+	t.Helper()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.Chdir(cwd) }
+}
+
+// tempJournal returns a journal directory holding a .gpgid, an entry and a
+// subdirectory, beside a directory outside it, and a function removing them.
+func tempJournal(t *testing.T) (string, string, func()) {
+	t.Helper()
+
+	base, err := ioutil.TempDir("", "journal-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base, err = filepath.EvalSymlinks(base); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(base, "journal")
+	outside := filepath.Join(base, "outside")
+	for _, path := range []string{filepath.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{".gpgid", "a.txt.gpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("me@example.com\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, outside, func() { os.RemoveAll(base) }
+}
+
+func TestJournalArgs(t *testing.T) {
+	dir, outside, cleanup := tempJournal(t)
+	defer cleanup()
+	defer setenv(map[string]string{envDir: ""})()
+	defer chdir(t, dir)()
+
+	tests := []struct {
+		name           string
+		args           []string
+		dir, remaining []string
+	}{
+		{name: "none"},
+		{name: "files only", args: []string{"a.txt.gpg", "2024-*"}, remaining: []string{"a.txt.gpg", "2024-*"}},
+		{name: "journal", args: []string{dir, "a.txt"}, dir: []string{dir}, remaining: []string{"a.txt"}},
+		{name: "subdirectory of entries", args: []string{"sub", "a.txt"}, remaining: []string{"sub", "a.txt"}},
+		{name: "directory outside the journal", args: []string{outside}, dir: []string{outside}, remaining: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, remaining := journalArgs(tt.args)
+			if !reflect.DeepEqual(dir, tt.dir) || !reflect.DeepEqual(remaining, tt.remaining) {
+				t.Errorf("journalArgs(%q) = %q, %q, want %q, %q", tt.args, dir, remaining, tt.dir, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestJournalRel(t *testing.T) {
+	dir, outside, cleanup := tempJournal(t)
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		cwd  string
+		path string
+		want string
+		err  string
+	}{
+		{name: "relative to a directory in the journal", cwd: filepath.Join(dir, "sub"), path: "a.txt", want: filepath.Join("sub", "a.txt")},
+		{name: "relative to the root from outside", cwd: outside, path: filepath.Join("sub", "a.txt"), want: filepath.Join("sub", "a.txt")},
+		{name: "absolute", cwd: outside, path: filepath.Join(dir, "a.txt"), want: "a.txt"},
+		{name: "outside the journal", cwd: dir, path: filepath.Join("..", "outside", "a.txt"), err: "is outside the journal"},
+		{name: "absolute outside the journal", cwd: dir, path: filepath.Join(outside, "a.txt"), err: "is outside the journal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer chdir(t, tt.cwd)()
+
+			got, err := journalRel(dir, tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %q, error %v, want an error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("journalRel(%s) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	dir, _, cleanup := tempJournal(t)
+	defer cleanup()
+	defer setenv(map[string]string{envDir: ""})()
+	defer func() { listJSON, statusJSON = false, false }()
+
+	for _, command := range []string{"list", "status"} {
+		t.Run(command, func(t *testing.T) {
+			var entries []journal.Entry
+			if err := json.Unmarshal([]byte(captureStdout(t, command, "--json", dir)), &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got entries %+v, want a.txt only", entries)
+			}
+			got := entries[0]
+			if got.Path != "a.txt.gpg" || got.Name != "a.txt" || got.State != "locked" || got.Modified == nil || got.Modified.IsZero() {
+				t.Errorf("got entry %+v, want a.txt.gpg locked with its modification time", got)
+			}
+		})
+	}
+}
+
+func TestStatusFormat(t *testing.T) {
+	dir, _, cleanup := tempJournal(t)
+	defer cleanup()
+	defer setenv(map[string]string{envDir: ""})()
+	defer func() { statusFormat = "" }()
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "default", want: "locked\n"},
+		{format: "{{.Total}} entries in {{.Dir}}", want: "1 entries in " + dir + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := captureStdout(t, "status", "--format", tt.format, dir); got != tt.want {
+				t.Errorf("status printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// version, commit and date describe the build, set with for example
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// A build without them reports the module version from its build info.
+var (
+	version = ""
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// buildInfo is the output of version --json.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	Go      string `json:"go"`
+}
+
+var (
+	versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit and build date of journal",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			info := currentBuild()
+			if versionJSON {
+				if err := writeJSON(os.Stdout, info); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+
+			fmt.Printf("journal %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.Date, info.Go)
+		},
+	}
+	versionJSON bool
+)
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "print the build information as JSON")
+	root.AddCommand(versionCmd)
+}
+
+// currentBuild describes the running binary.
+func currentBuild() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, Date: date, Go: runtime.Version()}
+	if info.Version == "" {
+		info.Version = "devel"
+		if build, ok := debug.ReadBuildInfo(); ok && build.Main.Version != "" {
+			info.Version = build.Main.Version
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs the command root is given args for, returning what it
+// writes to stdout.
+func captureStdout(t *testing.T, args ...string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	root.SetArgs(args)
+	err = root.Execute()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestVersion(t *testing.T) {
+	out := captureStdout(t, "version")
+	if !strings.HasPrefix(out, "journal ") || strings.HasPrefix(out, "journal  ") {
+		t.Errorf("got %q, want a version after journal", out)
+	}
+
+	defer func() { versionJSON = false }()
+	var info buildInfo
+	if err := json.Unmarshal([]byte(captureStdout(t, "version", "--json")), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version == "" || info.Commit == "" || info.Date == "" || !strings.HasPrefix(info.Go, "go") {
+		t.Errorf("got build info %+v, want every field set", info)
+	}
+}
+
+func TestVersionFromLdflags(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.0", "abc123", "2024-01-02T03:04:05Z"
+
+	info := currentBuild()
+	if info.Version != "v1.2.0" || info.Commit != "abc123" || info.Date != "2024-01-02T03:04:05Z" {
+		t.Errorf("got build info %+v, want the values set at link time", info)
+	}
+}
@@ -0,0 +1,107 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml"
+)
+
+// ConfigFile names the optional TOML file in a journal directory holding
+// defaults for that journal, for example:
+//
+//	gpg = "gpg2"
+//	ext = ".asc"
+//	plain-ext = ".md"
+//	recipient = ["alice@example.com", "bob@example.com"]
+//	armor = true
+//	compress = true
+//	date-layout = "2006/01/02"
+//	tmpdir = "/dev/shm"
+//	editor = "nano"
+//
+// Command line flags take precedence over the config file.
+var ConfigFile = ".journal"
+
+// Config holds the defaults read from a journal's ConfigFile.
+type Config struct {
+	GPG        string
+	Ext        string
+	PlainExt   string
+	Recipients []string
+	Armor      bool
+	Compress   bool
+	DateLayout string
+	TempDir    string
+	Editor     string
+}
+
+// LoadConfig reads the ConfigFile in dir. A missing file yields an empty
+// Config.
+func LoadConfig(dir string) (*Config, error) {
+	cfg := &Config{}
+
+	path := filepath.Join(dir, ConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", path, err)
+	}
+
+	for _, key := range tree.Keys() {
+		value := tree.Get(key)
+
+		var ok bool
+		switch key {
+		case "gpg":
+			cfg.GPG, ok = value.(string)
+		case "ext":
+			cfg.Ext, ok = value.(string)
+		case "plain-ext":
+			cfg.PlainExt, ok = value.(string)
+		case "armor":
+			cfg.Armor, ok = value.(bool)
+		case "compress":
+			cfg.Compress, ok = value.(bool)
+		case "date-layout":
+			cfg.DateLayout, ok = value.(string)
+		case "editor":
+			cfg.Editor, ok = value.(string)
+		case "tmpdir":
+			cfg.TempDir, ok = value.(string)
+		case "recipient":
+			cfg.Recipients, ok = stringList(value)
+		default:
+			return nil, fmt.Errorf("Error in %s: unknown key %q", path, key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("Error in %s: invalid value for %q", path, key)
+		}
+	}
+
+	return cfg, nil
+}
+
+// stringList accepts a single string or an array of strings.
+func stringList(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	}
+
+	return nil, false
+}
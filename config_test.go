@@ -0,0 +1,154 @@
+package journal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string // no config file if empty
+		want   Config
+		err    string
+	}{
+		{
+			name: "missing",
+		},
+		{
+			name: "every key",
+			config: `gpg = "gpg2"
+ext = ".asc"
+plain-ext = ".md"
+recipient = ["alice@example.com", "bob@example.com"]
+armor = true
+compress = true
+date-layout = "2006/01/02"
+tmpdir = "/dev/shm"
+editor = "nano"
+`,
+			want: Config{
+				GPG:        "gpg2",
+				Ext:        ".asc",
+				PlainExt:   ".md",
+				Recipients: []string{"alice@example.com", "bob@example.com"},
+				Armor:      true,
+				Compress:   true,
+				DateLayout: "2006/01/02",
+				TempDir:    "/dev/shm",
+				Editor:     "nano",
+			},
+		},
+		{
+			name:   "single recipient",
+			config: `recipient = "alice@example.com"`,
+			want:   Config{Recipients: []string{"alice@example.com"}},
+		},
+		{
+			name:   "unknown key",
+			config: `colour = "blue"`,
+			err:    `unknown key "colour"`,
+		},
+		{
+			name:   "invalid value",
+			config: `armor = "yes"`,
+			err:    `invalid value for "armor"`,
+		},
+		{
+			name:   "invalid recipient list",
+			config: `recipient = [1, 2]`,
+			err:    `invalid value for "recipient"`,
+		},
+		{
+			name:   "malformed",
+			config: `gpg = `,
+			err:    "Error reading",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			if tt.config != "" {
+				files[ConfigFile] = tt.config
+			}
+			dir, cleanup := tempJournal(t, files)
+			defer cleanup()
+
+			cfg, err := LoadConfig(dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*cfg, tt.want) {
+				t.Errorf("got %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{
+		ConfigFile: "gpg = \"gpg2\"\next = \".asc\"\nrecipient = \"alice@example.com\"\narmor = true\n",
+	})
+	defer cleanup()
+
+	yes, no := true, false
+
+	tests := []struct {
+		name string
+		opts Options
+		want Options
+	}{
+		{
+			name: "config fills in unset options",
+			want: Options{GPGCommand: "gpg2", Ext: ".asc", Recipients: []string{"alice@example.com"}, Armor: &yes, Compress: &no, AgeCommand: "age", DateLayout: EntryDateLayout},
+		},
+		{
+			name: "flags take precedence",
+			opts: Options{GPGCommand: "gpg", Ext: ".gpg", Recipients: []string{"bob@example.com"}, DateLayout: "2006/01/02"},
+			want: Options{GPGCommand: "gpg", Ext: ".gpg", Recipients: []string{"bob@example.com"}, Armor: &yes, Compress: &no, AgeCommand: "age", DateLayout: "2006/01/02"},
+		},
+		{
+			name: "a recipient file replaces the config's recipients",
+			opts: Options{RecipientFile: "recipients"},
+			want: Options{GPGCommand: "gpg2", Ext: ".asc", RecipientFile: "recipients", Armor: &yes, Compress: &no, AgeCommand: "age", DateLayout: EntryDateLayout},
+		},
+		{
+			name: "flags turn off the config's armor",
+			opts: Options{Armor: &no, Compress: &yes},
+			want: Options{GPGCommand: "gpg2", Ext: ".asc", Recipients: []string{"alice@example.com"}, Armor: &no, Compress: &yes, AgeCommand: "age", DateLayout: EntryDateLayout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if err := applyConfig(dir, &opts); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts, tt.want) {
+				t.Errorf("got %+v, want %+v", opts, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+
+	var opts Options
+	if err := applyConfig(dir, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.GPGCommand != "gpg" || opts.AgeCommand != "age" || opts.DateLayout != EntryDateLayout {
+		t.Errorf("got %+v, want the gpg, age and date layout defaults", opts)
+	}
+}
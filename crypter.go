@@ -0,0 +1,755 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Crypter encrypts and decrypts the files of a journal.
+type Crypter interface {
+	// Encrypt writes the encrypted contents of the file at in to out.
+	Encrypt(ctx context.Context, in, out string) error
+	// Decrypt writes the decrypted contents of the file at in to out.
+	Decrypt(ctx context.Context, in, out string) error
+	// DecryptTo writes the decrypted contents of the file at in to w,
+	// without writing plaintext to disk.
+	DecryptTo(ctx context.Context, in string, w io.Writer) error
+	// CheckRecipients returns an error if files cannot be encrypted to the
+	// configured recipients.
+	CheckRecipients(ctx context.Context) error
+}
+
+// GPGCrypter is a Crypter that runs gpg.
+//
+// When RootDir is set, files are encrypted to the recipients of the nearest
+// .gpgid between the file and RootDir, falling back to Recipients.
+// EncryptToSelf adds the user's default secret key to every file's
+// recipients, so a shared journal is never locked against its own owner.
+//
+// PassphraseFile names a file holding the passphrase of the secret key, or
+// the symmetric passphrase, so gpg can run without pinentry.
+//
+// Compress gzips plaintext before encrypting it, in place of gpg's own
+// compression.
+//
+// Homedir selects a GnuPG home directory other than the default, or
+// GNUPGHOME.
+//
+// StrictRecipients resolves each recipient to the fingerprint of the one key
+// it names before encrypting, failing if it names none or several, rather
+// than leave gpg to pick one.
+//
+// Interactive leaves out --batch when decrypting, so that gpg can prompt for
+// a smartcard's PIN or a touch through pinentry. gpg may then also stop to
+// ask questions on the terminal, so it suits a person at the keyboard rather
+// than scripts.
+//
+// Encryption and decryption succeed only once gpg's status output says so,
+// and the failures it explains are returned as StatusErrors.
+type GPGCrypter struct {
+	Command          string
+	Recipients       []string
+	RootDir          string
+	Symmetric        bool
+	Armor            bool
+	Passphrase       string
+	PassphraseFile   string
+	Homedir          string
+	Compress         bool
+	Retries          int
+	EncryptToSelf    bool
+	StrictRecipients bool
+	Interactive      bool
+	Verbose          bool
+
+	self     string            // fingerprint of the default secret key, once looked up
+	resolved map[string]string // fingerprints of strict recipients
+}
+
+func (g *GPGCrypter) Encrypt(ctx context.Context, in, out string) error {
+	args, err := g.encryptArgs(ctx, g.recipientsFor(out))
+	if err != nil {
+		return err
+	}
+	args = append(args, "--yes", "-o", out) // assume yes to most questions
+	if !g.Compress {
+		return g.runEncrypt(ctx, append(args, in), nil, nil)
+	}
+
+	plain, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+
+	return g.encryptFrom(ctx, args, plain, nil)
+}
+
+// EncryptStream writes the encrypted contents of r to w, encrypting to
+// Recipients.
+func (g *GPGCrypter) EncryptStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	args, err := g.encryptArgs(ctx, g.Recipients)
+	if err != nil {
+		return err
+	}
+
+	return g.encryptFrom(ctx, args, r, w)
+}
+
+// Reencrypt decrypts the file at in into memory and writes it to out,
+// encrypted to the recipients of out, so that no plaintext reaches disk.
+func (g *GPGCrypter) Reencrypt(ctx context.Context, in, out string) error {
+	var plain bytes.Buffer
+	if err := g.DecryptTo(ctx, in, &plain); err != nil {
+		return err
+	}
+
+	args, err := g.encryptArgs(ctx, g.recipientsFor(out))
+	if err != nil {
+		return err
+	}
+
+	return g.encryptFrom(ctx, append(args, "--yes", "-o", out), &plain, nil)
+}
+
+// encryptArgs returns the gpg arguments encrypting to recipients, or with a
+// passphrase.
+func (g *GPGCrypter) encryptArgs(ctx context.Context, recipients []string) ([]string, error) {
+	args := []string{
+		"-e",
+		"--batch", // non-interactive
+	}
+	if g.Armor {
+		args = append(args, "--armor")
+	}
+	if g.Symmetric {
+		args[0] = "--symmetric"
+		return append(args, g.passphraseFileArgs()...), nil
+	}
+
+	for _, recipient := range recipients {
+		if g.StrictRecipients {
+			fpr, err := g.resolveRecipient(ctx, recipient)
+			if err != nil {
+				return nil, err
+			}
+			recipient = fpr
+		}
+		args = append(args, "-r", recipient)
+	}
+	if g.EncryptToSelf {
+		self, err := g.selfRecipient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-r", self)
+	}
+
+	return args, nil
+}
+
+// encryptFrom runs gpg with args on the plaintext read from r, writing the
+// output to w if given.
+func (g *GPGCrypter) encryptFrom(ctx context.Context, args []string, r io.Reader, w io.Writer) error {
+	if !g.Compress {
+		return g.runEncrypt(ctx, args, r, w)
+	}
+
+	// gpg encrypts the gzipped plaintext from stdin without compressing it
+	// again
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, r)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return g.runEncrypt(ctx, append(args, "--compress-algo", "none"), pr, w)
+}
+
+// Decrypt passes no recipients: they are meaningless when decrypting, and gpg
+// picks the matching secret key itself.
+func (g *GPGCrypter) Decrypt(ctx context.Context, in, out string) error {
+	args := append([]string{"-d"}, g.batchArgs()...)
+	args = append(args,
+		"--yes", // assume yes to most questions
+		"-o", out,
+	)
+	args = append(args, g.passphraseFileArgs()...)
+	args = append(args, in)
+
+	if !g.Compress {
+		return g.decryptError(in, args, g.runDecrypt(ctx, args, nil, nil))
+	}
+
+	plain, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	err = g.DecryptTo(ctx, in, plain)
+	if cerr := plain.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// DecryptFiles decrypts each of files in a single gpg invocation, as gpg
+// --decrypt-files does: beside itself, less its .gpg, .asc or .pgp
+// extension. It returns the error for each file, taken from gpg's status
+// output, and cannot be used with Compress.
+func (g *GPGCrypter) DecryptFiles(ctx context.Context, files []string) []error {
+	args := append(g.batchArgs(), "--yes") // assume yes to most questions
+	args = append(args, g.passphraseFileArgs()...)
+	args = append(append(args, "--decrypt-files"), files...)
+
+	status, err := g.runStatus(ctx, args, nil, nil)
+	errs := fileErrors(len(files), status, err)
+	for i, err := range errs {
+		errs[i] = g.decryptError(files[i], args, err)
+	}
+	return errs
+}
+
+func (g *GPGCrypter) DecryptTo(ctx context.Context, in string, w io.Writer) error {
+	args := append([]string{"-d"}, g.batchArgs()...)
+	args = append(args, g.passphraseFileArgs()...)
+
+	args = append(args, in)
+
+	return g.decryptError(in, args, g.decryptTo(ctx, args, nil, w))
+}
+
+// DecryptStream writes the decrypted contents of r to w.
+func (g *GPGCrypter) DecryptStream(ctx context.Context, r io.Reader, w io.Writer) error {
+	args := append([]string{"-d"}, g.batchArgs()...)
+	args = append(args, g.passphraseFileArgs()...)
+
+	return g.decryptError("", args, g.decryptTo(ctx, args, r, w))
+}
+
+// decryptError wraps err, the failure to decrypt file with args, in a
+// DecryptError. It returns nil if err is nil.
+func (g *GPGCrypter) decryptError(file string, args []string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	derr := &DecryptError{File: file, Command: g.Command, Err: err}
+	if g.Verbose {
+		derr.Args = args
+	}
+	return derr
+}
+
+// decryptTo runs gpg with args, reading from stdin if given, and writes the
+// plaintext to w.
+func (g *GPGCrypter) decryptTo(ctx context.Context, args []string, stdin io.Reader, w io.Writer) error {
+	if !g.Compress {
+		return g.runDecrypt(ctx, args, stdin, w)
+	}
+
+	// entries encrypted before compression was turned on are passed
+	// through as they are
+	r, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := gunzip(w, r)
+		r.CloseWithError(err)
+		done <- err
+	}()
+
+	err := g.runDecrypt(ctx, args, stdin, pw)
+	pw.CloseWithError(err)
+	if zerr := <-done; err == nil && zerr != nil {
+		err = fmt.Errorf("cannot decompress: %s", zerr)
+	}
+	return err
+}
+
+// EncryptStream writes the contents of r encrypted by gpg to recipients to w.
+func EncryptStream(r io.Reader, w io.Writer, recipients []string) error {
+	g := &GPGCrypter{Command: "gpg", Recipients: recipients}
+	return g.EncryptStream(context.Background(), r, w)
+}
+
+// DecryptStream writes the contents of r decrypted by gpg to w.
+func DecryptStream(r io.Reader, w io.Writer) error {
+	g := &GPGCrypter{Command: "gpg"}
+	return g.DecryptStream(context.Background(), r, w)
+}
+
+// gunzip copies r to w, decompressing it if it begins with the gzip magic
+// number.
+func gunzip(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		_, err := io.Copy(w, br)
+		return err
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, zr)
+	return err
+}
+
+func (g *GPGCrypter) CheckRecipients(ctx context.Context) error {
+	if g.Symmetric {
+		return nil
+	}
+	if len(g.Recipients) == 0 {
+		return fmt.Errorf("no recipients configured")
+	}
+
+	recipients := g.Recipients
+	if g.RootDir != "" {
+		err := filepath.Walk(g.RootDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != g.RootDir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if info.IsDir() && path != g.RootDir {
+				if gpgid := gpgidPath(path); gpgid != "" {
+					content, err := ioutil.ReadFile(gpgid)
+					if err != nil {
+						return err
+					}
+					recipients = append(recipients, parseRecipients(content)...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("cannot read recipients: %s", err)
+		}
+	}
+
+	for _, recipient := range recipients {
+		if g.StrictRecipients {
+			if _, err := g.resolveRecipient(ctx, recipient); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := g.run(ctx, []string{"--batch", "--list-keys", recipient}, nil, nil); err != nil {
+			return fmt.Errorf("no public key found for recipient %s: %s", recipient, err)
+		}
+	}
+
+	if g.EncryptToSelf {
+		if _, err := g.selfRecipient(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selfRecipient returns the fingerprint of the user's default secret key,
+// which gpg takes to be the first in the secret keyring.
+func (g *GPGCrypter) selfRecipient(ctx context.Context) (string, error) {
+	if g.self != "" {
+		return g.self, nil
+	}
+
+	var out bytes.Buffer
+	if err := g.run(ctx, []string{"--batch", "--with-colons", "--list-secret-keys"}, nil, &out); err != nil {
+		return "", fmt.Errorf("cannot find own key to encrypt to: %s", err)
+	}
+
+	// the fpr record following the first sec record is the primary key's
+	sec := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "sec":
+			sec = true
+		case fields[0] == "fpr" && sec && len(fields) > 9:
+			g.self = fields[9]
+			return g.self, nil
+		}
+	}
+
+	return "", fmt.Errorf("cannot find own key to encrypt to: no secret keys")
+}
+
+// onlySecretKey returns the fingerprint of the user's secret key, failing
+// unless there is exactly one that is neither revoked nor expired.
+func (g *GPGCrypter) onlySecretKey(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	if err := g.run(ctx, []string{"--batch", "--with-colons", "--list-secret-keys"}, nil, &out); err != nil {
+		return "", fmt.Errorf("cannot list secret keys: %s", err)
+	}
+
+	// each usable sec record is a key, followed by its fpr record
+	var fprs []string
+	sec := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "sec":
+			sec = len(fields) > 1 && !strings.ContainsAny(fields[1], "rde")
+		case fields[0] == "fpr" && sec && len(fields) > 9:
+			fprs = append(fprs, fields[9])
+			sec = false
+		}
+	}
+
+	switch len(fprs) {
+	case 0:
+		return "", fmt.Errorf("no secret key found to encrypt to; pass --recipient")
+	case 1:
+		return fprs[0], nil
+	default:
+		return "", fmt.Errorf("found %d secret keys (%s); pass --recipient to choose one", len(fprs), strings.Join(fprs, ", "))
+	}
+}
+
+// resolveRecipient returns the fingerprint of the only public key matching
+// recipient, which may be a fingerprint, key id or email address.
+func (g *GPGCrypter) resolveRecipient(ctx context.Context, recipient string) (string, error) {
+	if fpr, ok := g.resolved[recipient]; ok {
+		return fpr, nil
+	}
+
+	var out bytes.Buffer
+	if err := g.run(ctx, []string{"--batch", "--with-colons", "--list-keys", recipient}, nil, &out); err != nil {
+		return "", fmt.Errorf("no public key found for recipient %s: %s", recipient, err)
+	}
+
+	// each pub record is a matching key, followed by its fpr record
+	var fprs []string
+	pub := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			pub = true
+		case fields[0] == "fpr" && pub && len(fields) > 9:
+			fprs = append(fprs, fields[9])
+			pub = false
+		}
+	}
+
+	switch len(fprs) {
+	case 0:
+		return "", fmt.Errorf("no public key found for recipient %s", recipient)
+	case 1:
+	default:
+		return "", fmt.Errorf("recipient %s is ambiguous, matching keys %s; name one by its fingerprint", recipient, strings.Join(fprs, ", "))
+	}
+
+	if g.resolved == nil {
+		g.resolved = make(map[string]string)
+	}
+	g.resolved[recipient] = fprs[0]
+	return fprs[0], nil
+}
+
+// batchArgs returns the arguments keeping gpg from prompting while it
+// decrypts, none if it is Interactive.
+func (g *GPGCrypter) batchArgs() []string {
+	if g.Interactive {
+		return nil
+	}
+	return []string{"--batch"} // non-interactive
+}
+
+// passphraseFileArgs returns the arguments having gpg read its passphrase from
+// PassphraseFile instead of asking pinentry.
+func (g *GPGCrypter) passphraseFileArgs() []string {
+	if g.PassphraseFile == "" {
+		return nil
+	}
+	return []string{"--pinentry-mode", "loopback", "--passphrase-file", g.PassphraseFile}
+}
+
+// recipientsFor returns the recipients to encrypt the file at path to.
+func (g *GPGCrypter) recipientsFor(path string) []string {
+	if gpgid := g.gpgidFor(path); gpgid != "" {
+		if content, err := ioutil.ReadFile(gpgid); err == nil {
+			return parseRecipients(content)
+		}
+	}
+
+	return g.Recipients
+}
+
+// gpgidFor returns the .gpgid of the subdirectory nearest the file at path
+// that names its own recipients, or "" if Recipients apply to it.
+func (g *GPGCrypter) gpgidFor(path string) string {
+	if g.RootDir == "" {
+		return ""
+	}
+
+	for dir := filepath.Dir(path); dir != g.RootDir; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(g.RootDir, dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			break
+		}
+
+		if gpgid := gpgidPath(dir); gpgid != "" {
+			return gpgid
+		}
+	}
+
+	return ""
+}
+
+// transientErrors are the diagnostics of gpg failures that are worth
+// retrying, such as the agent losing a race with pinentry.
+var transientErrors = []string{
+	"agent refused operation",
+	"can't connect to the agent",
+	"problem with the agent",
+	"Resource temporarily unavailable",
+}
+
+// runEncrypt runs gpg to encrypt as run does, judging success by its status
+// output.
+func (g *GPGCrypter) runEncrypt(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	status, err := g.runStatus(ctx, args, stdin, stdout)
+	return encryptionError(status, err)
+}
+
+// runDecrypt runs gpg to decrypt as run does, judging success by its status
+// output.
+func (g *GPGCrypter) runDecrypt(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	status, err := g.runStatus(ctx, args, stdin, stdout)
+	return decryptionError(status, err)
+}
+
+// run invokes gpg with args, reading its input from stdin and writing its
+// output to stdout if given. gpg's diagnostics are included in the returned
+// error. Transient failures are retried up to Retries times with a doubling
+// delay, unless gpg has consumed stdin or written output already.
+func (g *GPGCrypter) run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	_, err := g.runStatus(ctx, args, stdin, stdout)
+	return err
+}
+
+// runStatus runs gpg as run does, returning the status lines of its last
+// attempt too.
+func (g *GPGCrypter) runStatus(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) ([]string, error) {
+	// status lines are told apart from gpg's diagnostics by their prefix
+	args = append([]string{"--status-fd", "2"}, args...)
+	if g.Homedir != "" {
+		args = append([]string{"--homedir", g.Homedir}, args...)
+	}
+
+	var out *countingWriter
+	if stdout != nil {
+		out = &countingWriter{w: stdout}
+		stdout = out
+	}
+
+	delay := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		stderr, status, err := g.runOnce(ctx, args, stdin, stdout)
+		if err == nil || attempt >= g.Retries || stdin != nil || (out != nil && out.n > 0) || !transient(stderr) {
+			return status, err
+		}
+
+		if g.Verbose {
+			fmt.Printf("Retrying %s in %s: %s\n", g.Command, delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return status, err
+		}
+		delay *= 2
+	}
+}
+
+// transient reports whether gpg's diagnostics show a failure worth retrying.
+func transient(stderr string) bool {
+	for _, msg := range transientErrors {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// countingWriter counts the bytes written through it to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// runOnce invokes gpg a single time for run, returning its diagnostics and
+// status lines along with any error.
+func (g *GPGCrypter) runOnce(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) (string, []string, error) {
+	if g.Verbose {
+		fmt.Printf("Executing %s %s\n", g.Command, strings.Join(args, " "))
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, g.Command, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if g.Passphrase != "" && stdin == nil {
+		cmd.Args = append([]string{g.Command, "--pinentry-mode", "loopback", "--passphrase-fd", "0"}, args...)
+		cmd.Stdin = strings.NewReader(g.Passphrase + "\n")
+	} else if g.Passphrase != "" {
+		// stdin carries the input, so hand the passphrase over on fd 3
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return "", nil, err
+		}
+		defer pr.Close()
+		go func() {
+			io.WriteString(pw, g.Passphrase+"\n")
+			pw.Close()
+		}()
+		cmd.Args = append([]string{g.Command, "--pinentry-mode", "loopback", "--passphrase-fd", "3"}, args...)
+		cmd.ExtraFiles = []*os.File{pr}
+	}
+	start := time.Now()
+	err := cmd.Run()
+	status, diagnostics := splitStatus(stderr.String())
+	if err != nil {
+		return diagnostics, status, commandError(ctx, g.Command, start, err, bytes.NewBufferString(diagnostics))
+	}
+
+	return "", status, nil
+}
+
+// gpgidPath returns the recipient file in dir: .gpgid, or .gpg-id as used by
+// pass when only that exists. It returns "" if dir has neither.
+func gpgidPath(dir string) string {
+	for _, name := range []string{".gpgid", ".gpg-id"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+
+	return ""
+}
+
+// parseRecipients returns the recipients listed one per line in content,
+// skipping blank lines and comments starting with #.
+func parseRecipients(content []byte) []string {
+	var recipients []string
+	for _, line := range strings.Split(string(content), "\n") {
+		recipient := strings.TrimSpace(line)
+		if recipient == "" || strings.HasPrefix(recipient, "#") {
+			continue
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients
+}
+
+// AgeRecipientsFile names the file in a journal directory listing its age
+// recipients. It has one recipient per line, either an age public key
+// (age1...) or an SSH public key; blank lines and lines starting with # are
+// ignored.
+var AgeRecipientsFile = ".age-recipients"
+
+// AgeCrypter is a Crypter that runs age.
+type AgeCrypter struct {
+	Command        string
+	RecipientsFile string
+	Identity       string
+	Armor          bool
+	Verbose        bool
+}
+
+func (a *AgeCrypter) Encrypt(ctx context.Context, in, out string) error {
+	args := []string{"-e", "-R", a.RecipientsFile, "-o", out}
+	if a.Armor {
+		args = append(args, "-a")
+	}
+	args = append(args, in)
+
+	return a.run(ctx, args, nil)
+}
+
+func (a *AgeCrypter) Decrypt(ctx context.Context, in, out string) error {
+	args := []string{"-d", "-o", out}
+	if a.Identity != "" {
+		args = append(args, "-i", a.Identity)
+	}
+	args = append(args, in)
+
+	return a.run(ctx, args, nil)
+}
+
+func (a *AgeCrypter) DecryptTo(ctx context.Context, in string, w io.Writer) error {
+	args := []string{"-d"}
+	if a.Identity != "" {
+		args = append(args, "-i", a.Identity)
+	}
+	args = append(args, in)
+
+	return a.run(ctx, args, w)
+}
+
+func (a *AgeCrypter) CheckRecipients(ctx context.Context) error {
+	if _, err := os.Stat(a.RecipientsFile); err != nil {
+		return fmt.Errorf("cannot read age recipients: %s", err)
+	}
+
+	return nil
+}
+
+// run invokes age with args, writing its output to stdout if given. age's
+// diagnostics are included in the returned error.
+func (a *AgeCrypter) run(ctx context.Context, args []string, stdout io.Writer) error {
+	if a.Verbose {
+		fmt.Printf("Executing %s %s\n", a.Command, strings.Join(args, " "))
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.Command, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return commandError(ctx, a.Command, start, err, &stderr)
+	}
+
+	return nil
+}
+
+// commandError describes the failure of command, started at start, using its
+// diagnostics on stderr or the reason ctx ended.
+func commandError(ctx context.Context, command string, start time.Time, err error, stderr *bytes.Buffer) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s killed after timing out (%s elapsed)", command, time.Since(start).Round(time.Millisecond))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s cancelled: %s", command, ctx.Err())
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %s", err, msg)
+	}
+	return err
+}
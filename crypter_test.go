@@ -0,0 +1,691 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGPGCrypterRoundTrip(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		crypter GPGCrypter
+	}{
+		{name: "recipients", crypter: GPGCrypter{Recipients: []string{"me@example.com", "two@example.com"}}},
+		{name: "armor", crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Armor: true}},
+		{name: "compress", crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Compress: true}},
+		{name: "symmetric", crypter: GPGCrypter{Symmetric: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the spaces must reach gpg as part of the paths
+			dir, cleanup := tempJournal(t, map[string]string{"my diary.txt": "dear diary\n"})
+			defer cleanup()
+
+			g := tt.crypter
+			g.Command = fake.command()
+			plain := filepath.Join(dir, "my diary.txt")
+			enc := filepath.Join(dir, "my diary.txt.gpg")
+			out := filepath.Join(dir, "decrypted diary.txt")
+			ctx := context.Background()
+
+			if err := g.Encrypt(ctx, plain, enc); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Decrypt(ctx, enc, out); err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "dear diary\n" {
+				t.Errorf("decrypted %q, want %q", content, "dear diary\n")
+			}
+
+			var buf bytes.Buffer
+			if err := g.DecryptTo(ctx, enc, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "dear diary\n" {
+				t.Errorf("decrypted %q to a writer, want %q", buf.String(), "dear diary\n")
+			}
+		})
+	}
+}
+
+func TestGPGCrypterEncryptArgs(t *testing.T) {
+	me := defaultFakeKeys[0].FPR
+	tests := []struct {
+		name    string
+		crypter GPGCrypter
+		want    []string // recipients
+		args    []string // present in gpg's arguments
+		absent  []string
+	}{
+		{
+			name:    "recipients as separate arguments",
+			crypter: GPGCrypter{Recipients: []string{"Me <me@example.com>", "two@example.com"}},
+			want:    []string{"Me <me@example.com>", "two@example.com"},
+			args:    []string{"-e", "--batch", "--yes"},
+			absent:  []string{"--armor", "--symmetric"},
+		},
+		{
+			name:    "armor",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Armor: true},
+			want:    []string{"me@example.com"},
+			args:    []string{"--armor"},
+		},
+		{
+			name:    "symmetric",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Symmetric: true, PassphraseFile: "/run/pass"},
+			args:    []string{"--symmetric", "--pinentry-mode", "loopback", "--passphrase-file", "/run/pass"},
+			absent:  []string{"-e", "-r"},
+		},
+		{
+			name:    "encrypt to self",
+			crypter: GPGCrypter{Recipients: []string{"two@example.com"}, EncryptToSelf: true},
+			want:    []string{"two@example.com", me},
+		},
+		{
+			name:    "strict recipients",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, StrictRecipients: true},
+			want:    []string{me},
+		},
+		{
+			name:    "compress",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Compress: true},
+			want:    []string{"me@example.com"},
+			args:    []string{"--compress-algo", "none"},
+		},
+		{
+			name:    "homedir",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Homedir: "/home/me/.gnupg-journal"},
+			want:    []string{"me@example.com"},
+			args:    []string{"--homedir", "/home/me/.gnupg-journal"},
+		},
+		{
+			name:    "interactive still encrypts in batch mode",
+			crypter: GPGCrypter{Recipients: []string{"me@example.com"}, Interactive: true},
+			want:    []string{"me@example.com"},
+			args:    []string{"--batch"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := tempJournal(t, map[string]string{"a b.txt": "a"})
+			defer cleanup()
+			fake, cleanup := newFakeGPG(t)
+			defer cleanup()
+
+			g := tt.crypter
+			g.Command = fake.command()
+			if err := g.Encrypt(context.Background(), filepath.Join(dir, "a b.txt"), filepath.Join(dir, "a b.txt.gpg")); err != nil {
+				t.Fatal(err)
+			}
+
+			runs := fake.runs(t)
+			args := runs[len(runs)-1]
+			if got := argValues(args, "-r"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("encrypted to %q, want %q", got, tt.want)
+			}
+			if got := argValues(args, "-o"); len(got) != 1 || got[0] != filepath.Join(dir, "a b.txt.gpg") {
+				t.Errorf("got output %q, want %s", got, filepath.Join(dir, "a b.txt.gpg"))
+			}
+			if !hasArg(args, filepath.Join(dir, "a b.txt")) && !g.Compress {
+				t.Errorf("got arguments %q, want the input path as one of them", args)
+			}
+			for _, arg := range tt.args {
+				if !hasArg(args, arg) {
+					t.Errorf("got arguments %q, want %s among them", args, arg)
+				}
+			}
+			for _, arg := range tt.absent {
+				if hasArg(args, arg) {
+					t.Errorf("got arguments %q, want no %s", args, arg)
+				}
+			}
+			if args[0] != "--homedir" && (args[0] != "--status-fd" || args[1] != "2") {
+				t.Errorf("got arguments %q, want them to start with --status-fd 2", args)
+			}
+		})
+	}
+}
+
+func TestGPGCrypterDecryptArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		crypter GPGCrypter
+		args    []string
+		absent  []string
+	}{
+		{
+			name:   "batch",
+			args:   []string{"-d", "--batch", "--yes"},
+			absent: []string{"--pinentry-mode"},
+		},
+		{
+			name:    "passphrase file",
+			crypter: GPGCrypter{PassphraseFile: "/run/pass"},
+			args:    []string{"--pinentry-mode", "loopback", "--passphrase-file", "/run/pass"},
+		},
+		{
+			name:    "passphrase",
+			crypter: GPGCrypter{Passphrase: "secret"},
+			args:    []string{"--pinentry-mode", "loopback", "--passphrase-fd", "0"},
+			absent:  []string{"secret"},
+		},
+		{
+			name:    "interactive",
+			crypter: GPGCrypter{Interactive: true},
+			args:    []string{"-d"},
+			absent:  []string{"--batch"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := tempJournal(t, nil)
+			defer cleanup()
+			fake, cleanup := newFakeGPG(t)
+			defer cleanup()
+
+			enc := filepath.Join(dir, "a b.txt.gpg")
+			fake.encrypt(t, enc, "a", defaultFakeKeys[0].keyID())
+			g := tt.crypter
+			g.Command = fake.command()
+			if err := g.Decrypt(context.Background(), enc, filepath.Join(dir, "a b.txt")); err != nil {
+				t.Fatal(err)
+			}
+
+			args := fake.runs(t)[0]
+			if !hasArg(args, enc) {
+				t.Errorf("got arguments %q, want %s among them", args, enc)
+			}
+			for _, arg := range tt.args {
+				if !hasArg(args, arg) {
+					t.Errorf("got arguments %q, want %s among them", args, arg)
+				}
+			}
+			for _, arg := range tt.absent {
+				if hasArg(args, arg) {
+					t.Errorf("got arguments %q, want no %s", args, arg)
+				}
+			}
+		})
+	}
+}
+
+func TestGPGCrypterErrors(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+	dir, cleanup := tempJournal(t, map[string]string{"a.txt": "a", "plain.txt.gpg": "not encrypted"})
+	defer cleanup()
+
+	other := filepath.Join(dir, "other.txt.gpg")
+	fake.encrypt(t, other, "b", defaultFakeKeys[1].keyID())
+	g := &GPGCrypter{Command: fake.command(), Recipients: []string{"nobody@example.com"}}
+	ctx := context.Background()
+
+	err := g.Encrypt(ctx, filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.txt.gpg"))
+	if !errors.Is(err, ErrInvalidRecipient) || !strings.Contains(err.Error(), "No public key") {
+		t.Errorf("got error %v encrypting to an unknown recipient, want ErrInvalidRecipient with gpg's diagnostics", err)
+	}
+
+	err = g.Decrypt(ctx, other, filepath.Join(dir, "other.txt"))
+	var derr *DecryptError
+	if !errors.As(err, &derr) || derr.File != other || !errors.Is(err, ErrNoSecretKey) {
+		t.Errorf("got error %v decrypting without the secret key, want a DecryptError for %s wrapping ErrNoSecretKey", err, other)
+	}
+	if err == nil || !strings.Contains(err.Error(), "decryption failed: No secret key") {
+		t.Errorf("got error %v, want it to include gpg's diagnostics", err)
+	}
+
+	err = g.Decrypt(ctx, filepath.Join(dir, "plain.txt.gpg"), filepath.Join(dir, "plain.txt"))
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("got error %v decrypting a file that is not encrypted, want ErrNoData", err)
+	}
+
+	if err := g.CheckRecipients(ctx); err == nil || !strings.Contains(err.Error(), "nobody@example.com") {
+		t.Errorf("got error %v checking an unknown recipient, want one naming it", err)
+	}
+}
+
+func TestGPGCrypterTimeout(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+
+	enc := filepath.Join(dir, "a.txt.gpg")
+	fake.encrypt(t, enc, "a", defaultFakeKeys[0].keyID())
+	os.Setenv(fakeGPGSleepEnv, "10s")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	g := &GPGCrypter{Command: fake.command()}
+	start := time.Now()
+	err := g.Decrypt(ctx, enc, filepath.Join(dir, "a.txt"))
+	if err == nil || !strings.Contains(err.Error(), "killed after timing out") {
+		t.Errorf("got error %v, want gpg to time out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gpg ran for %s despite its timeout", elapsed)
+	}
+}
+
+func TestGPGCrypterRecipientsFor(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{
+		"a.txt.gpg":              "",
+		"work/.gpgid":            "# work\nwork@example.com\n\nboss@example.com\n",
+		"work/notes/b.txt.gpg":   "",
+		"shared/.gpg-id":         "pass@example.com\n",
+		"shared/c.txt.gpg":       "",
+		"work/private/.gpgid":    "me@example.com\n",
+		"work/private/d.txt.gpg": "",
+	})
+	defer cleanup()
+
+	g := &GPGCrypter{Recipients: []string{"me@example.com"}, RootDir: dir}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"a.txt.gpg", []string{"me@example.com"}},
+		{"work/notes/b.txt.gpg", []string{"work@example.com", "boss@example.com"}},
+		{"shared/c.txt.gpg", []string{"pass@example.com"}},
+		{"work/private/d.txt.gpg", []string{"me@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := g.recipientsFor(filepath.Join(dir, filepath.FromSlash(tt.path))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("recipientsFor(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGPGCrypterStreams(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+
+	for _, compress := range []bool{false, true} {
+		g := &GPGCrypter{Command: fake.command(), Recipients: []string{"me@example.com"}, Compress: compress}
+		var enc, plain bytes.Buffer
+		if err := g.EncryptStream(context.Background(), strings.NewReader("streamed"), &enc); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.DecryptStream(context.Background(), &enc, &plain); err != nil {
+			t.Fatal(err)
+		}
+		if plain.String() != "streamed" {
+			t.Errorf("compress %v: got %q back, want %q", compress, plain.String(), "streamed")
+		}
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"", nil},
+		{"me@example.com", []string{"me@example.com"}},
+		{"Me <me@example.com>\r\ntwo@example.com\n", []string{"Me <me@example.com>", "two@example.com"}},
+		{"# the journal's readers\n\n  me@example.com  \n# two@example.com\n", []string{"me@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := parseRecipients([]byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRecipients(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestGPGCrypterDecryptPassesNoRecipient(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+
+	a, b := filepath.Join(dir, "a.txt.gpg"), filepath.Join(dir, "b.txt.gpg")
+	fake.encrypt(t, a, "a", defaultFakeKeys[0].keyID())
+	fake.encrypt(t, b, "b", defaultFakeKeys[0].keyID())
+	g := &GPGCrypter{Command: fake.command(), Recipients: []string{"me@example.com", "two@example.com"}, RootDir: dir}
+	ctx := context.Background()
+
+	if err := g.Decrypt(ctx, a, filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.DecryptTo(ctx, a, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := ioutil.ReadFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.DecryptStream(ctx, bytes.NewReader(enc), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range g.DecryptFiles(ctx, []string{a, b}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs := fake.runs(t)
+	if len(runs) != 4 {
+		t.Fatalf("got %d gpg runs, want 4", len(runs))
+	}
+	for _, args := range runs {
+		if hasArg(args, "-r") || hasArg(args, "--recipient") {
+			t.Errorf("decrypted with arguments %q, want no recipient", args)
+		}
+	}
+}
+
+func TestGPGCrypterRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		wantRuns int
+		wantErr  bool
+	}{
+		{name: "no failure", retries: 2, wantRuns: 1},
+		{name: "retried", retries: 2, failures: 2, wantRuns: 3},
+		{name: "out of retries", retries: 1, failures: 2, wantRuns: 2, wantErr: true},
+		{name: "no retries", failures: 1, wantRuns: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, cleanup := newFakeGPG(t)
+			defer cleanup()
+			dir, cleanup := tempJournal(t, nil)
+			defer cleanup()
+
+			enc := filepath.Join(dir, "a.txt.gpg")
+			fake.encrypt(t, enc, "a", defaultFakeKeys[0].keyID())
+			fake.failNext(t, tt.failures)
+
+			g := &GPGCrypter{Command: fake.command(), Retries: tt.retries}
+			err := g.Decrypt(context.Background(), enc, filepath.Join(dir, "a.txt"))
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "agent refused operation")) {
+				t.Errorf("got error %v, want the agent's failure", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if runs := len(fake.runs(t)); runs != tt.wantRuns {
+				t.Errorf("ran gpg %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestGPGCrypterRetriesWithoutReplayingStdin(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+	fake.failNext(t, 1)
+
+	// the plaintext read from stdin cannot be read again
+	g := &GPGCrypter{Command: fake.command(), Recipients: []string{"me@example.com"}, Retries: 3}
+	err := g.EncryptStream(context.Background(), strings.NewReader("a"), ioutil.Discard)
+	if err == nil {
+		t.Error("got no error, want the failure of the only run")
+	}
+	if runs := len(fake.runs(t)); runs != 1 {
+		t.Errorf("ran gpg %d times, want 1", runs)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"gpg: decryption failed: agent refused operation\n", true},
+		{"gpg: can't connect to the agent: IPC connect call failed\n", true},
+		{"gpg: problem with the agent: Resource temporarily unavailable\n", true},
+		{"gpg: decryption failed: No secret key\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := transient(tt.stderr); got != tt.want {
+			t.Errorf("transient(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRecipient(t *testing.T) {
+	keys := []fakeKey{
+		{Name: "Alice <alice@example.com>", FPR: "1111111111111111111111111111111111111111"},
+		{Name: "Alice <alice@work.example>", FPR: "2222222222222222222222222222222222222222"},
+		{Name: "Bob <bob@example.com>", FPR: "3333333333333333333333333333333333333333"},
+	}
+	tests := []struct {
+		recipient string
+		want      string
+		err       string
+	}{
+		{recipient: "bob@example.com", want: keys[2].FPR},
+		{recipient: keys[1].FPR, want: keys[1].FPR},
+		{recipient: keys[0].keyID(), want: keys[0].FPR},
+		{recipient: "Alice", err: "ambiguous, matching keys " + keys[0].FPR + ", " + keys[1].FPR},
+		{recipient: "carol@example.com", err: "no public key found for recipient carol@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.recipient, func(t *testing.T) {
+			fake, cleanup := newFakeGPG(t, keys...)
+			defer cleanup()
+
+			g := &GPGCrypter{Command: fake.command()}
+			fpr, err := g.resolveRecipient(context.Background(), tt.recipient)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %s, %v; want an error containing %q", fpr, err, tt.err)
+				}
+				return
+			}
+			if err != nil || fpr != tt.want {
+				t.Fatalf("got %s, %v; want %s", fpr, err, tt.want)
+			}
+
+			// resolved recipients are remembered
+			if _, err := g.resolveRecipient(context.Background(), tt.recipient); err != nil {
+				t.Fatal(err)
+			}
+			if runs := len(fake.runs(t)); runs != 1 {
+				t.Errorf("listed keys %d times, want once", runs)
+			}
+		})
+	}
+}
+
+func TestOnlySecretKey(t *testing.T) {
+	mine := fakeKey{Name: "Me <me@example.com>", FPR: "1111111111111111111111111111111111111111", Secret: true}
+	work := fakeKey{Name: "Me <me@work.example>", FPR: "2222222222222222222222222222222222222222", Secret: true}
+	revoked := fakeKey{Name: "Me <me@old.example>", FPR: "3333333333333333333333333333333333333333", Secret: true, Validity: "r"}
+	public := fakeKey{Name: "Two <two@example.com>", FPR: "4444444444444444444444444444444444444444"}
+
+	tests := []struct {
+		name string
+		keys []fakeKey
+		want string
+		err  string
+	}{
+		{name: "one", keys: []fakeKey{public, mine}, want: mine.FPR},
+		{name: "one besides a revoked key", keys: []fakeKey{revoked, mine}, want: mine.FPR},
+		{name: "none", keys: []fakeKey{public, revoked}, err: "no secret key found"},
+		{name: "several", keys: []fakeKey{mine, work}, err: "found 2 secret keys (" + mine.FPR + ", " + work.FPR + ")"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, cleanup := newFakeGPG(t, tt.keys...)
+			defer cleanup()
+
+			g := &GPGCrypter{Command: fake.command()}
+			fpr, err := g.onlySecretKey(context.Background())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %s, %v; want an error containing %q", fpr, err, tt.err)
+				}
+				return
+			}
+			if err != nil || fpr != tt.want {
+				t.Fatalf("got %s, %v; want %s", fpr, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeCrypterRoundTrip(t *testing.T) {
+	fake, cleanup := newFakeAge(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		crypter AgeCrypter
+	}{
+		{name: "recipients"},
+		{name: "armor", crypter: AgeCrypter{Armor: true}},
+		{name: "identity", crypter: AgeCrypter{Identity: "key.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := tempJournal(t, map[string]string{
+				"my diary.txt":    "dear diary\n",
+				AgeRecipientsFile: "# me\nage1me\n\nage1two\n",
+				"key.txt":         "AGE-SECRET-KEY-1\n",
+			})
+			defer cleanup()
+
+			a := tt.crypter
+			a.Command = fake.command()
+			a.RecipientsFile = filepath.Join(dir, AgeRecipientsFile)
+			if a.Identity != "" {
+				a.Identity = filepath.Join(dir, a.Identity)
+			}
+			plain := filepath.Join(dir, "my diary.txt")
+			enc := filepath.Join(dir, "my diary.txt.age")
+			out := filepath.Join(dir, "decrypted diary.txt")
+			ctx := context.Background()
+
+			if err := a.Encrypt(ctx, plain, enc); err != nil {
+				t.Fatal(err)
+			}
+			content, recipients := fake.decrypt(t, enc)
+			if content != "dear diary\n" || !reflect.DeepEqual(recipients, []string{"age1me", "age1two"}) {
+				t.Errorf("encrypted %q to %v, want %q to the recipients file's keys", content, recipients, "dear diary\n")
+			}
+
+			if err := a.Decrypt(ctx, enc, out); err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != "dear diary\n" {
+				t.Errorf("decrypted %q, want %q", decrypted, "dear diary\n")
+			}
+
+			var buf bytes.Buffer
+			if err := a.DecryptTo(ctx, enc, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "dear diary\n" {
+				t.Errorf("decrypted %q to a writer, want %q", buf.String(), "dear diary\n")
+			}
+		})
+	}
+}
+
+func TestAgeCrypterArgs(t *testing.T) {
+	fake, cleanup := newFakeAge(t)
+	defer cleanup()
+	dir, cleanup := tempJournal(t, map[string]string{"a.txt": "a", AgeRecipientsFile: "age1me\n"})
+	defer cleanup()
+
+	recipients := filepath.Join(dir, AgeRecipientsFile)
+	plain := filepath.Join(dir, "a.txt")
+	enc := filepath.Join(dir, "a.txt.age")
+	identity := filepath.Join(dir, AgeRecipientsFile) // any readable file will do
+	tests := []struct {
+		name    string
+		crypter AgeCrypter
+		run     func(a *AgeCrypter) error
+		want    []string
+	}{
+		{
+			name: "encrypt",
+			run:  func(a *AgeCrypter) error { return a.Encrypt(context.Background(), plain, enc) },
+			want: []string{"-e", "-R", recipients, "-o", enc, plain},
+		},
+		{
+			name:    "encrypt armored",
+			crypter: AgeCrypter{Armor: true},
+			run:     func(a *AgeCrypter) error { return a.Encrypt(context.Background(), plain, enc) },
+			want:    []string{"-e", "-R", recipients, "-o", enc, "-a", plain},
+		},
+		{
+			name: "decrypt",
+			run:  func(a *AgeCrypter) error { return a.Decrypt(context.Background(), enc, plain) },
+			want: []string{"-d", "-o", plain, enc},
+		},
+		{
+			name:    "decrypt with an identity",
+			crypter: AgeCrypter{Identity: identity},
+			run:     func(a *AgeCrypter) error { return a.DecryptTo(context.Background(), enc, ioutil.Discard) },
+			want:    []string{"-d", "-i", identity, enc},
+		},
+	}
+
+	fake.encrypt(t, enc, "a", "age1me")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(fake.log)
+			a := tt.crypter
+			a.Command = fake.command()
+			a.RecipientsFile = recipients
+			if err := tt.run(&a); err != nil {
+				t.Fatal(err)
+			}
+			runs := fake.runs(t)
+			if len(runs) != 1 || !reflect.DeepEqual(runs[0], tt.want) {
+				t.Errorf("ran age with %v, want %v", runs, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeCrypterErrors(t *testing.T) {
+	fake, cleanup := newFakeAge(t)
+	defer cleanup()
+	dir, cleanup := tempJournal(t, map[string]string{"plain.txt.age": "not encrypted"})
+	defer cleanup()
+
+	a := &AgeCrypter{Command: fake.command(), RecipientsFile: filepath.Join(dir, AgeRecipientsFile)}
+	ctx := context.Background()
+
+	if err := a.CheckRecipients(ctx); err == nil || !strings.Contains(err.Error(), "cannot read age recipients") {
+		t.Errorf("got error %v checking a missing recipients file, want one saying so", err)
+	}
+	err := a.Decrypt(ctx, filepath.Join(dir, "plain.txt.age"), filepath.Join(dir, "plain.txt"))
+	if err == nil || !strings.Contains(err.Error(), "failed to read header") {
+		t.Errorf("got error %v decrypting a file that is not encrypted, want age's diagnostics", err)
+	}
+}
@@ -0,0 +1,143 @@
+package journal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EncChecklistFile names the file in which Lock records the hash of each
+// encrypted file, so that VerifyEncrypted can find those changed outside the
+// journal, by a sync conflict or bit rot for example.
+var EncChecklistFile = ".enc-check"
+
+// VerifyEncrypted compares each encrypted file with the hash recorded when it
+// was last written, writing to w those modified, missing or never recorded,
+// followed by a summary. It returns an error if any file did not match.
+func (j *Journal) VerifyEncrypted(w io.Writer) error {
+	recorded, err := j.readEncChecklist()
+	if err != nil {
+		return err
+	}
+	if len(recorded.files) == 0 {
+		return fmt.Errorf("Error: no hashes of encrypted files are recorded in %s; run journal lock to record them", EncChecklistFile)
+	}
+
+	current := &Checklist{root: j.RootDir}
+	if err := j.hashEncrypted(current, j.Files); err != nil {
+		return err
+	}
+
+	mismatches := 0
+	for _, file := range current.files {
+		hash, ok := recorded.hash(file.path)
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "UNRECORDED %s\n", j.relPath(file.path))
+		case hash != file.hash:
+			fmt.Fprintf(w, "MODIFIED %s\n", j.relPath(file.path))
+		default:
+			continue
+		}
+		mismatches++
+	}
+	for _, path := range current.Added(recorded) {
+		fmt.Fprintf(w, "MISSING %s\n", j.relPath(path))
+		mismatches++
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("Verify failed: %d encrypted files do not match %s", mismatches, EncChecklistFile)
+	}
+
+	fmt.Fprintf(w, "Verified journal: %d encrypted files match %s\n", len(current.files), EncChecklistFile)
+	return nil
+}
+
+// hashEncrypted records the hash of the encrypted file of each of files in
+// checklist, under the path it has in a locked journal.
+func (j *Journal) hashEncrypted(checklist *Checklist, files []FilePair) error {
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file.encrypted())
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %s", file.encrypted(), err)
+		}
+		checklist.Remove(file.enc)
+		checklist.AddFile(file.enc, hashContent(sha256.New(), content))
+	}
+
+	return nil
+}
+
+// updateEncChecklist applies update to the journal's EncChecklistFile. A
+// journal without one yet has it seeded with every encrypted file first, so
+// that those written before the first lock are not left unrecorded.
+func (j *Journal) updateEncChecklist(update func(checklist *Checklist) error) error {
+	if j.dryRun {
+		return nil
+	}
+
+	checklist, err := j.readEncChecklist()
+	if err != nil {
+		return err
+	}
+	if !exists(filepath.Join(j.RootDir, EncChecklistFile)) {
+		if err := j.hashEncrypted(checklist, j.Files); err != nil {
+			return err
+		}
+	}
+	if err := update(checklist); err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.RootDir, EncChecklistFile)
+	checkfile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %s", path, err)
+	}
+	defer checkfile.Close()
+
+	checkWriter := bufio.NewWriter(checkfile)
+	if err := checklist.Write(checkWriter); err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+	if err := checkWriter.Flush(); err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// seedEncChecklist writes the journal's EncChecklistFile from its encrypted
+// files if it has none yet.
+func (j *Journal) seedEncChecklist() error {
+	if exists(filepath.Join(j.RootDir, EncChecklistFile)) {
+		return nil
+	}
+
+	return j.updateEncChecklist(func(*Checklist) error { return nil })
+}
+
+// readEncChecklist loads the journal's EncChecklistFile, which is empty if
+// none has been written.
+func (j *Journal) readEncChecklist() (*Checklist, error) {
+	path := filepath.Join(j.RootDir, EncChecklistFile)
+	checkfile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Checklist{root: j.RootDir}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", path, err)
+	}
+	defer checkfile.Close()
+
+	checklist, err := ChecklistFromReader(bufio.NewReader(checkfile), j.RootDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", path, err)
+	}
+	return checklist, nil
+}
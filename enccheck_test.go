@@ -0,0 +1,66 @@
+package journal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerifyEncrypted(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	defer cleanup()
+
+	var out bytes.Buffer
+	if err := tj.open(t, Options{}).VerifyEncrypted(&out); err == nil || !strings.Contains(err.Error(), "no hashes of encrypted files are recorded") {
+		t.Errorf("got error %v before the first lock, want none recorded", err)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a edited")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := tj.open(t, Options{}).VerifyEncrypted(&out); err != nil {
+		t.Fatalf("got error %v after lock, output:\n%s", err, out.String())
+	}
+	if want := "Verified journal: 3 encrypted files match .enc-check\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+
+	tj.write(t, "a.txt.gpg", tj.read(t, "a.txt.gpg")+"corrupted")
+	tj.remove(t, "b.txt.gpg")
+	tj.fake.encrypt(t, tj.path("d.txt.gpg"), "d", defaultFakeKeys[0].keyID())
+
+	out.Reset()
+	err := tj.open(t, Options{}).VerifyEncrypted(&out)
+	if err == nil || !strings.Contains(err.Error(), "3 encrypted files do not match") {
+		t.Errorf("got error %v, want 3 mismatches", err)
+	}
+	if want := "MODIFIED a.txt.gpg\nUNRECORDED d.txt.gpg\nMISSING b.txt.gpg\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
+
+func TestEncChecklistSeededBeforeFirstUnlock(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+
+	// only a.txt is unlocked and locked again, b.txt.gpg is still recorded
+	// as it was first found
+	if err := tj.open(t, Options{Only: []string{"a.txt"}}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a edited")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := tj.open(t, Options{}).VerifyEncrypted(&out); err != nil {
+		t.Errorf("got error %v, output:\n%s", err, out.String())
+	}
+}
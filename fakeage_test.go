@@ -0,0 +1,226 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	fakeAgeEnv    = "JOURNAL_FAKE_AGE"
+	fakeAgeLogEnv = "JOURNAL_FAKE_AGE_LOG" // file each invocation's arguments are appended to
+	fakeAgeHeader = "age-encryption.org/v1 fake"
+	fakeAgeArmor  = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// fakeAge configures the fake age for a test.
+type fakeAge struct {
+	dir string
+	log string
+}
+
+// newFakeAge sets up the fake age for the duration of a test, returning it
+// and a function undoing it.
+func newFakeAge(t testing.TB) (*fakeAge, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "journal-fakeage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeAge{dir: dir, log: filepath.Join(dir, "log")}
+	os.Setenv(fakeAgeEnv, "1")
+	os.Setenv(fakeAgeLogEnv, f.log)
+
+	return f, func() {
+		os.Unsetenv(fakeAgeEnv)
+		os.Unsetenv(fakeAgeLogEnv)
+		os.RemoveAll(dir)
+	}
+}
+
+// command returns the age command running the fake.
+func (f *fakeAge) command() string {
+	return os.Args[0]
+}
+
+// runs returns the arguments of each run of the fake so far.
+func (f *fakeAge) runs(t testing.TB) [][]string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(f.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		var args []string
+		if err := json.Unmarshal([]byte(line), &args); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, args)
+	}
+	return runs
+}
+
+// encrypt writes content to path as the fake age encrypts it to recipients.
+func (f *fakeAge) encrypt(t testing.TB, path, content string, recipients ...string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	data := fakeAgeHeader + " " + strings.Join(recipients, ",") + "\n" + content
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decrypt returns the plaintext of the fake age file at path and the
+// recipients it is encrypted to.
+func (f *fakeAge) decrypt(t testing.TB, path string) (string, []string) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, plain, ok := parseFakeAge(content)
+	if !ok {
+		t.Fatalf("%s is not encrypted: %q", path, content)
+	}
+	return string(plain), recipients
+}
+
+func parseFakeAge(content []byte) ([]string, []byte, bool) {
+	content = bytes.TrimPrefix(content, []byte(fakeAgeArmor+"\n"))
+	if !bytes.HasPrefix(content, []byte(fakeAgeHeader)) {
+		return nil, nil, false
+	}
+	header := content
+	rest := []byte(nil)
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		header, rest = content[:i], content[i+1:]
+	}
+
+	var recipients []string
+	if fields := strings.TrimSpace(strings.TrimPrefix(string(header), fakeAgeHeader)); fields != "" {
+		recipients = strings.Split(fields, ",")
+	}
+	return recipients, rest, true
+}
+
+// runFakeAge behaves as age would for the arguments AgeCrypter passes, on
+// files encrypted as fakeAge.encrypt writes them, and returns its exit
+// status.
+func runFakeAge(args []string, stdout, stderr io.Writer) int {
+	if log := os.Getenv(fakeAgeLogEnv); log != "" {
+		line, _ := json.Marshal(args)
+		if f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err == nil {
+			f.Write(append(line, '\n'))
+			f.Close()
+		}
+	}
+
+	var decrypt, armor bool
+	var recipientsFile, identity, output, input string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-e":
+		case "-d":
+			decrypt = true
+		case "-a":
+			armor = true
+		case "-R", "-i", "-o":
+			if i+1 == len(args) {
+				fmt.Fprintf(stderr, "age: error: flag needs an argument: %s\n", arg)
+				return 1
+			}
+			i++
+			switch arg {
+			case "-R":
+				recipientsFile = args[i]
+			case "-i":
+				identity = args[i]
+			case "-o":
+				output = args[i]
+			}
+		default:
+			input = arg
+		}
+	}
+
+	content, err := ioutil.ReadFile(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "age: error: failed to open input file %q\n", input)
+		return 1
+	}
+
+	var out []byte
+	if decrypt {
+		if identity != "" {
+			if _, err := os.Stat(identity); err != nil {
+				fmt.Fprintf(stderr, "age: error: failed to open identity file %q\n", identity)
+				return 1
+			}
+		}
+		_, plain, ok := parseFakeAge(content)
+		if !ok {
+			fmt.Fprintln(stderr, "age: error: failed to read header: parsing age header: unexpected intro")
+			return 1
+		}
+		out = plain
+	} else {
+		recipients, err := readFakeAgeRecipients(recipientsFile)
+		if err != nil || len(recipients) == 0 {
+			fmt.Fprintf(stderr, "age: error: failed to read recipients file %q\n", recipientsFile)
+			return 1
+		}
+		out = []byte(fakeAgeHeader + " " + strings.Join(recipients, ",") + "\n" + string(content))
+		if armor {
+			out = append([]byte(fakeAgeArmor+"\n"), out...)
+		}
+	}
+
+	if output == "" {
+		stdout.Write(out)
+		return 0
+	}
+	if err := ioutil.WriteFile(output, out, 0600); err != nil {
+		fmt.Fprintf(stderr, "age: error: failed to open output file %q\n", output)
+		return 1
+	}
+	return 0
+}
+
+// readFakeAgeRecipients reads a recipients file as age does, skipping blank
+// lines and comments.
+func readFakeAgeRecipients(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recipients []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipients = append(recipients, line)
+	}
+	return recipients, scanner.Err()
+}
@@ -0,0 +1,163 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	fakeEditorArg     = "-fake-editor"            // first argument of the test binary run as the fake editor
+	fakeEditorEnv     = "JOURNAL_FAKE_EDITOR"     // "+" and the text the fake editor appends, "fail" or "interrupt"
+	fakeEditorLogEnv  = "JOURNAL_FAKE_EDITOR_LOG" // file each edit is recorded in
+	fakeEditorNewFile = "new.txt"                 // file the fake editor writes given a directory
+)
+
+// fakeEdit records a run of the fake editor: the file it was given and what
+// that file held.
+type fakeEdit struct {
+	Path    string
+	Content string
+}
+
+// runFakeEditor appends the text of fakeEditorEnv to the file named by the
+// last of args, recording the edit, and returns the exit status. It exits
+// with 1 without writing anything if fakeEditorEnv is "fail", and with 130
+// after interrupting the journal running it if it is "interrupt". Given a
+// directory, as a session is, it records the names of the files in it as the
+// content and writes the text to fakeEditorNewFile there.
+func runFakeEditor(args []string) int {
+	if len(args) == 0 {
+		return 2
+	}
+	path := args[len(args)-1]
+	var content []byte
+	if infos, err := ioutil.ReadDir(path); err == nil {
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		content = []byte(strings.Join(names, " "))
+	} else if content, err = ioutil.ReadFile(path); err != nil {
+		return 2
+	}
+
+	log, err := os.OpenFile(os.Getenv(fakeEditorLogEnv), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 2
+	}
+	defer log.Close()
+	if err := json.NewEncoder(log).Encode(fakeEdit{Path: path, Content: string(content)}); err != nil {
+		return 2
+	}
+
+	text := os.Getenv(fakeEditorEnv)
+	switch text {
+	case "fail":
+		return 1
+	case "interrupt":
+		// Ctrl-C reaches the journal as well as the editor it is waiting on
+		if parent, err := os.FindProcess(os.Getppid()); err == nil {
+			parent.Signal(os.Interrupt)
+		}
+		return 130
+	}
+	text = strings.TrimPrefix(text, "+")
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		if text == "" {
+			return 0
+		}
+		path, content = filepath.Join(path, fakeEditorNewFile), nil
+	}
+	if err := ioutil.WriteFile(path, append(content, text...), 0600); err != nil {
+		return 2
+	}
+	return 0
+}
+
+// fakeEditor makes the test binary the editor run by the journal, appending
+// text to each file edited, or failing if text is "fail" or "interrupt",
+// until the second function returned is called. The first returns the edits
+// made.
+func fakeEditor(t testing.TB, text string) (func(t testing.TB) []fakeEdit, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "journal-fakeeditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "log")
+	restore := setenv(map[string]string{
+		"VISUAL":         os.Args[0] + " " + fakeEditorArg,
+		"EDITOR":         "",
+		fakeEditorEnv:    fakeEditorText(text),
+		fakeEditorLogEnv: log,
+	})
+
+	edits := func(t testing.TB) []fakeEdit {
+		t.Helper()
+
+		file, err := os.Open(log)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		var edits []fakeEdit
+		dec := json.NewDecoder(file)
+		for dec.More() {
+			var edit fakeEdit
+			if err := dec.Decode(&edit); err != nil {
+				t.Fatal(err)
+			}
+			edits = append(edits, edit)
+		}
+		return edits
+	}
+	return edits, func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeEditorText returns the value of fakeEditorEnv appending text.
+func fakeEditorText(text string) string {
+	if text == "fail" || text == "interrupt" {
+		return text
+	}
+	return "+" + text
+}
+
+// setenv sets the environment variables in env, an empty value unsetting
+// one, and returns a function restoring them.
+func setenv(env map[string]string) func() {
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
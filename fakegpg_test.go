@@ -0,0 +1,533 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs the test binary as a fake gpg when fakeGPGEnv is set, so that
+// GPGCrypter and the journal can be tested without gpg or a keyring, as a
+// fake age when fakeAgeEnv is set, and as a fake editor when run as
+// fakeEditorArg.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == fakeEditorArg {
+		os.Exit(runFakeEditor(os.Args[2:]))
+	}
+	if os.Getenv(fakeAgeEnv) != "" {
+		os.Exit(runFakeAge(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	if os.Getenv(fakeGPGEnv) != "" {
+		os.Exit(runFakeGPG(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	// with -race, each fake run would otherwise sleep a second as it exits
+	os.Setenv("GORACE", strings.TrimSpace(os.Getenv("GORACE")+" atexit_sleep_ms=0"))
+	os.Exit(m.Run())
+}
+
+const (
+	fakeGPGEnv       = "JOURNAL_FAKE_GPG"
+	fakeGPGLogEnv    = "JOURNAL_FAKE_GPG_LOG"   // file each invocation's arguments are appended to
+	fakeGPGKeysEnv   = "JOURNAL_FAKE_GPG_KEYS"  // the keyring, as JSON
+	fakeGPGFailEnv   = "JOURNAL_FAKE_GPG_FAIL"  // file holding the number of runs to fail transiently
+	fakeGPGSleepEnv  = "JOURNAL_FAKE_GPG_SLEEP" // delay before each run
+	fakeGPGEmptyEnv  = "JOURNAL_FAKE_GPG_EMPTY" // decrypt to empty files without reporting success
+	fakeGPGHeader    = "-----BEGIN FAKE GPG-----"
+	fakeGPGArmorLine = "-----FAKE ARMOR-----"
+)
+
+// fakeKey is a key in the fake gpg's keyring. Recipients are matched by
+// substring of Name, or by fingerprint or key id, as gpg matches them.
+type fakeKey struct {
+	Name     string
+	FPR      string
+	Secret   bool
+	Validity string // gpg's validity field, "u" when empty
+}
+
+func (k fakeKey) keyID() string {
+	return k.FPR[len(k.FPR)-16:]
+}
+
+func (k fakeKey) matches(query string) bool {
+	return strings.Contains(k.Name, query) || query == k.FPR || query == k.keyID()
+}
+
+// fakeGPG configures the fake gpg for a test.
+type fakeGPG struct {
+	dir  string
+	log  string
+	keys []fakeKey
+}
+
+// defaultFakeKeys are the keys a fake gpg holds unless a test sets others:
+// the user's own key pair, and another person's public key.
+var defaultFakeKeys = []fakeKey{
+	{Name: "Me <me@example.com>", FPR: "B29BAA257C4C25731BA2D262AECFE6582E716793", Secret: true},
+	{Name: "Two <two@example.com>", FPR: "5D1F0E9B7A8C6D4E3F2A1B0C9D8E7F6A5B4C3D2E"},
+}
+
+// newFakeGPG sets up the fake gpg with keys for the duration of a test,
+// returning it and a function undoing it.
+func newFakeGPG(t testing.TB, keys ...fakeKey) (*fakeGPG, func()) {
+	t.Helper()
+
+	if len(keys) == 0 {
+		keys = defaultFakeKeys
+	}
+	dir, err := ioutil.TempDir("", "journal-fakegpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeGPG{dir: dir, log: filepath.Join(dir, "log"), keys: keys}
+
+	keyring, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		fakeGPGEnv:     "1",
+		fakeGPGLogEnv:  f.log,
+		fakeGPGKeysEnv: string(keyring),
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+
+	return f, func() {
+		for _, name := range []string{fakeGPGEnv, fakeGPGLogEnv, fakeGPGKeysEnv, fakeGPGFailEnv, fakeGPGSleepEnv, fakeGPGEmptyEnv} {
+			os.Unsetenv(name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// command returns the gpg command running the fake.
+func (f *fakeGPG) command() string {
+	return os.Args[0]
+}
+
+// failNext makes the next n runs fail as if the agent had refused them.
+func (f *fakeGPG) failNext(t testing.TB, n int) {
+	t.Helper()
+
+	path := filepath.Join(f.dir, "fail")
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(n)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(fakeGPGFailEnv, path)
+}
+
+// runs returns the arguments of each run of the fake so far.
+func (f *fakeGPG) runs(t testing.TB) [][]string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(f.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		var args []string
+		if err := json.Unmarshal([]byte(line), &args); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, args)
+	}
+	return runs
+}
+
+// encrypt writes content to path as the fake gpg encrypts it to recipients.
+func (f *fakeGPG) encrypt(t testing.TB, path, content string, recipients ...string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	data := fakeGPGHeader + " " + strings.Join(recipients, ",") + "\n" + content
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decrypt returns the plaintext of the fake encrypted file at path and the
+// recipients it is encrypted to.
+func (f *fakeGPG) decrypt(t testing.TB, path string) (string, []string) {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, plain, ok := parseFakeEncrypted(content)
+	if !ok {
+		t.Fatalf("%s is not encrypted: %q", path, content)
+	}
+	return string(plain), recipients
+}
+
+// hasArg reports whether args holds arg.
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// argValues returns the values following each flag in args.
+func argValues(args []string, flag string) []string {
+	var values []string
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
+
+func parseFakeEncrypted(content []byte) ([]string, []byte, bool) {
+	content = bytes.TrimPrefix(content, []byte(fakeGPGArmorLine+"\n"))
+	if !bytes.HasPrefix(content, []byte(fakeGPGHeader)) {
+		return nil, nil, false
+	}
+	header := content
+	rest := []byte(nil)
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		header, rest = content[:i], content[i+1:]
+	}
+
+	var recipients []string
+	if fields := strings.TrimSpace(strings.TrimPrefix(string(header), fakeGPGHeader)); fields != "" {
+		recipients = strings.Split(fields, ",")
+	}
+	return recipients, rest, true
+}
+
+// fakeRun is a single invocation of the fake gpg.
+type fakeRun struct {
+	keys       []fakeKey
+	status     io.Writer
+	stderr     io.Writer
+	output     string
+	recipients []string
+	files      []string
+	armor      bool
+	symmetric  bool
+	empty      bool
+}
+
+// runFakeGPG behaves as gpg would for the arguments GPGCrypter passes, on
+// files encrypted as fakeGPG.encrypt writes them, and returns its exit
+// status.
+func runFakeGPG(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if log := os.Getenv(fakeGPGLogEnv); log != "" {
+		line, _ := json.Marshal(args)
+		if f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err == nil {
+			f.Write(append(line, '\n'))
+			f.Close()
+		}
+	}
+	if delay, err := time.ParseDuration(os.Getenv(fakeGPGSleepEnv)); err == nil {
+		time.Sleep(delay)
+	}
+	if path := os.Getenv(fakeGPGFailEnv); path != "" {
+		content, _ := ioutil.ReadFile(path)
+		if n, _ := strconv.Atoi(string(content)); n > 0 {
+			ioutil.WriteFile(path, []byte(strconv.Itoa(n-1)), 0600)
+			fmt.Fprintln(stderr, "gpg: decryption failed: agent refused operation")
+			return 2
+		}
+	}
+
+	run := &fakeRun{stderr: stderr, empty: os.Getenv(fakeGPGEmptyEnv) != ""}
+	if err := json.Unmarshal([]byte(os.Getenv(fakeGPGKeysEnv)), &run.keys); err != nil {
+		fmt.Fprintf(stderr, "gpg: bad keyring: %s\n", err)
+		return 2
+	}
+
+	var mode string
+	statusFD := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() string {
+			i++
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+		switch arg {
+		case "--status-fd":
+			statusFD = value()
+		case "--homedir", "--pinentry-mode", "--passphrase-file", "--passphrase-fd", "--compress-algo":
+			value()
+		case "-o":
+			run.output = value()
+		case "-r":
+			run.recipients = append(run.recipients, value())
+		case "--armor":
+			run.armor = true
+		case "--symmetric":
+			run.symmetric = true
+			mode = "-e"
+		case "-e", "-d", "--decrypt-files", "--list-keys", "--list-secret-keys":
+			if mode != "--list-only" {
+				mode = arg
+			}
+		case "--list-only":
+			mode = arg
+		case "--batch", "--yes", "--with-colons":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(stderr, "gpg: invalid option %q\n", arg)
+				return 2
+			}
+			run.files = append(run.files, arg)
+		}
+	}
+	run.status = ioutil.Discard
+	if statusFD == "2" {
+		run.status = stderr
+	}
+
+	switch mode {
+	case "-e":
+		return run.encrypt(stdin, stdout)
+	case "-d":
+		return run.decryptOne(stdin, stdout)
+	case "--decrypt-files":
+		return run.decryptFiles()
+	case "--list-only":
+		return run.listOnly()
+	case "--list-keys":
+		return run.listKeys(stdout, false)
+	case "--list-secret-keys":
+		return run.listKeys(stdout, true)
+	}
+	fmt.Fprintf(stderr, "gpg: no command in %q\n", args)
+	return 2
+}
+
+func (r *fakeRun) statusf(format string, args ...interface{}) {
+	fmt.Fprintf(r.status, statusPrefix+format+"\n", args...)
+}
+
+// input reads the file named by the run, or stdin.
+func (r *fakeRun) input(stdin io.Reader) ([]byte, error) {
+	if len(r.files) > 0 {
+		return ioutil.ReadFile(r.files[0])
+	}
+	return ioutil.ReadAll(stdin)
+}
+
+// write writes content to the run's output, or stdout.
+func (r *fakeRun) write(content []byte, stdout io.Writer) error {
+	if r.output == "" || r.output == "-" {
+		_, err := stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(r.output, content, 0600)
+}
+
+// key returns the key matching name, failing if there is not exactly one.
+func (r *fakeRun) key(name string) (fakeKey, bool) {
+	var found []fakeKey
+	for _, key := range r.keys {
+		if key.matches(name) {
+			found = append(found, key)
+		}
+	}
+	if len(found) != 1 {
+		return fakeKey{}, false
+	}
+	return found[0], true
+}
+
+func (r *fakeRun) encrypt(stdin io.Reader, stdout io.Writer) int {
+	var ids []string
+	for _, recipient := range r.recipients {
+		key, ok := r.key(recipient)
+		if !ok {
+			fmt.Fprintf(r.stderr, "gpg: %s: skipped: No public key\n", recipient)
+			r.statusf("INV_RECP 1 %s", recipient)
+			r.statusf("FAILURE encrypt 53")
+			return 2
+		}
+		ids = append(ids, key.keyID())
+	}
+	if len(ids) == 0 && !r.symmetric {
+		fmt.Fprintln(r.stderr, "gpg: no valid addressees")
+		return 2
+	}
+
+	plain, err := r.input(stdin)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "gpg: can't open: %s\n", err)
+		return 2
+	}
+
+	var out bytes.Buffer
+	if r.armor {
+		out.WriteString(fakeGPGArmorLine + "\n")
+	}
+	fmt.Fprintf(&out, "%s %s\n", fakeGPGHeader, strings.Join(ids, ","))
+	out.Write(plain)
+
+	r.statusf("BEGIN_ENCRYPTION 2 9")
+	if err := r.write(out.Bytes(), stdout); err != nil {
+		fmt.Fprintf(r.stderr, "gpg: can't create: %s\n", err)
+		return 2
+	}
+	r.statusf("END_ENCRYPTION")
+	return 0
+}
+
+// decrypt writes the plaintext of content to write, reporting its status
+// as gpg does.
+func (r *fakeRun) decrypt(content []byte, write func([]byte) error) bool {
+	recipients, plain, ok := parseFakeEncrypted(content)
+	if !ok {
+		fmt.Fprintln(r.stderr, "gpg: no valid OpenPGP data found.")
+		r.statusf("NODATA 1")
+		return false
+	}
+
+	secret := len(recipients) == 0 // symmetric
+	for _, id := range recipients {
+		r.statusf("ENC_TO %s 1 0", id)
+		key, ok := r.key(id)
+		if ok && key.Secret {
+			secret = true
+		} else {
+			r.statusf("NO_SECKEY %s", id)
+		}
+	}
+	r.statusf("BEGIN_DECRYPTION")
+	if !secret {
+		fmt.Fprintln(r.stderr, "gpg: decryption failed: No secret key")
+		r.statusf("DECRYPTION_FAILED")
+		r.statusf("END_DECRYPTION")
+		return false
+	}
+
+	// a silent failure exits cleanly having written nothing, but without
+	// reporting DECRYPTION_OKAY
+	if r.empty {
+		if err := write(nil); err != nil {
+			fmt.Fprintf(r.stderr, "gpg: can't create: %s\n", err)
+			return false
+		}
+		r.statusf("END_DECRYPTION")
+		return true
+	}
+	if err := write(plain); err != nil {
+		fmt.Fprintf(r.stderr, "gpg: can't create: %s\n", err)
+		return false
+	}
+	r.statusf("DECRYPTION_OKAY")
+	r.statusf("GOODMDC")
+	r.statusf("END_DECRYPTION")
+	return true
+}
+
+func (r *fakeRun) decryptOne(stdin io.Reader, stdout io.Writer) int {
+	content, err := r.input(stdin)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "gpg: can't open: %s\n", err)
+		return 2
+	}
+	if !r.decrypt(content, func(plain []byte) error { return r.write(plain, stdout) }) {
+		return 2
+	}
+	return 0
+}
+
+func (r *fakeRun) decryptFiles() int {
+	status := 0
+	for _, file := range r.files {
+		r.statusf("FILE_START 3 %s", file)
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(r.stderr, "gpg: can't open '%s': %s\n", file, err)
+			status = 2
+			continue
+		}
+
+		ext := filepath.Ext(file)
+		out := strings.TrimSuffix(file, ext)
+		ok := r.decrypt(content, func(plain []byte) error {
+			return ioutil.WriteFile(out, plain, 0600)
+		})
+		if !ok {
+			status = 2
+		}
+		r.statusf("FILE_DONE")
+	}
+	return status
+}
+
+func (r *fakeRun) listOnly() int {
+	content, err := r.input(nil)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "gpg: can't open: %s\n", err)
+		return 2
+	}
+	recipients, _, ok := parseFakeEncrypted(content)
+	if !ok {
+		r.statusf("NODATA 1")
+		return 2
+	}
+	for _, id := range recipients {
+		r.statusf("ENC_TO %s 1 0", id)
+	}
+	return 0
+}
+
+func (r *fakeRun) listKeys(stdout io.Writer, secret bool) int {
+	w := bufio.NewWriter(stdout)
+	defer w.Flush()
+
+	found := 0
+	for _, key := range r.keys {
+		if secret && !key.Secret {
+			continue
+		}
+		if len(r.files) > 0 && !key.matches(r.files[0]) {
+			continue
+		}
+
+		record, validity := "pub", key.Validity
+		if secret {
+			record = "sec"
+		}
+		if validity == "" {
+			validity = "u"
+		}
+		fmt.Fprintf(w, "%s:%s:3072:1:%s:1600000000:::u:::scESC:::+:::23::0:\n", record, validity, key.keyID())
+		fmt.Fprintf(w, "fpr:::::::::%s:\n", key.FPR)
+		fmt.Fprintf(w, "uid:%s::::1600000000::0::%s::::::::::0:\n", validity, key.Name)
+		found++
+	}
+
+	if found == 0 && len(r.files) > 0 {
+		fmt.Fprintf(r.stderr, "gpg: error reading key: No public key\n")
+		return 2
+	}
+	return 0
+}
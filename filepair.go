@@ -0,0 +1,163 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FilePair is an encrypted file of the journal and the plaintext it unlocks
+// to. hidden is set while the encrypted file sits at its footprint.
+type FilePair struct {
+	enc    string
+	plain  string
+	hidden bool
+}
+
+func (fp FilePair) Decrypt(j *Journal) error {
+	if j.dryRun {
+		fmt.Printf("Would decrypt %s to %s\n", fp.encrypted(), fp.plain)
+		return nil
+	}
+
+	if !fp.EncExists() {
+		return fmt.Errorf("encrypted file %s missing, cannot decrypt", fp.encrypted())
+	}
+
+	ctx, cancel := j.context()
+	defer cancel()
+	if err := j.crypter.Decrypt(ctx, fp.encrypted(), fp.plain); err != nil {
+		return err
+	}
+
+	return checkDecrypted(fp.plain)
+}
+
+// checkDecrypted guards against a decryption to plain that reported success
+// without writing it. An empty plaintext is a valid entry: it is gpg's
+// DECRYPTION_OKAY, not the size of its output, that tells success apart from
+// a silent failure.
+func checkDecrypted(plain string) error {
+	if _, err := os.Stat(plain); err != nil {
+		return fmt.Errorf("decryption produced no file %s: %s", plain, err)
+	}
+
+	return nil
+}
+
+func (fp FilePair) Encrypt(j *Journal) error {
+	if j.dryRun {
+		fmt.Printf("Would encrypt %s to %s\n", fp.plain, fp.enc)
+		return nil
+	}
+
+	if !fp.PlainExists() {
+		return fmt.Errorf("plaintext file %s missing, cannot encrypt", fp.plain)
+	}
+
+	ctx, cancel := j.context()
+	defer cancel()
+	return j.crypter.Encrypt(ctx, fp.plain, fp.enc)
+}
+
+// encryptStaged encrypts the plaintext to the staging file and moves it over
+// the encrypted file, so a failure leaves the old encrypted file intact.
+func (fp FilePair) encryptStaged(j *Journal) error {
+	if j.dryRun {
+		return fp.Encrypt(j)
+	}
+
+	stage := FilePair{enc: fp.staging(), plain: fp.plain}
+	if err := stage.Encrypt(j); err != nil {
+		os.Remove(stage.enc)
+		return err
+	}
+	if err := os.Rename(stage.enc, fp.enc); err != nil {
+		os.Remove(stage.enc)
+		return err
+	}
+	return nil
+}
+
+func (fp FilePair) LeaveFootprint(j *Journal) error {
+	if j.dryRun {
+		fmt.Printf("Would move %s to %s\n", fp.enc, fp.footprint())
+		return nil
+	}
+
+	// already moved, e.g. by an unlock that failed part way
+	if !exists(fp.enc) {
+		if exists(fp.footprint()) {
+			return nil
+		}
+		return fmt.Errorf("encrypted file %s missing, cannot leave a footprint", fp.enc)
+	}
+
+	return os.Rename(fp.enc, fp.footprint())
+}
+
+func (fp FilePair) RemoveFootprint(j *Journal) error {
+	if j.dryRun {
+		fmt.Printf("Would remove %s\n", fp.footprint())
+		return nil
+	}
+
+	if err := os.Remove(fp.footprint()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (fp FilePair) ResetFootprint(j *Journal) error {
+	if j.dryRun {
+		fmt.Printf("Would move %s to %s\n", fp.footprint(), fp.enc)
+		return nil
+	}
+
+	// already reset
+	if !exists(fp.footprint()) {
+		if exists(fp.enc) {
+			return nil
+		}
+		return fmt.Errorf("footprint %s missing, cannot reset %s", fp.footprint(), fp.enc)
+	}
+
+	return os.Rename(fp.footprint(), fp.enc)
+}
+
+// EncExists reports whether the encrypted file is at its current location.
+func (fp FilePair) EncExists() bool {
+	return exists(fp.encrypted())
+}
+
+// PlainExists reports whether the plaintext of the file exists.
+func (fp FilePair) PlainExists() bool {
+	return exists(fp.plain)
+}
+
+// staging returns the hidden path the encrypted file is written to while the
+// journal is being locked.
+func (fp FilePair) staging() string {
+	return fp.footprint() + ".tmp"
+}
+
+// encrypted returns the current location of the encrypted file, which is its
+// footprint while the journal is unlocked.
+func (fp FilePair) encrypted() string {
+	if fp.hidden {
+		return fp.footprint()
+	}
+	return fp.enc
+}
+
+// footprint returns the hidden path the encrypted file is moved to while the
+// journal is unlocked.
+func (fp FilePair) footprint() string {
+	return filepath.Join(filepath.Dir(fp.enc), "."+filepath.Base(fp.enc))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,120 @@
+package journal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFootprint(t *testing.T) {
+	tests := []struct {
+		enc, footprint string
+	}{
+		{"/journal/entry.gpg", "/journal/.entry.gpg"},
+		{"/journal/sub/my entry.txt.gpg", "/journal/sub/.my entry.txt.gpg"},
+		{"/journal/.profile.gpg", "/journal/..profile.gpg"},
+	}
+	for _, tt := range tests {
+		fp := FilePair{enc: tt.enc}
+		if got := fp.footprint(); got != tt.footprint {
+			t.Errorf("footprint of %s = %s, want %s", tt.enc, got, tt.footprint)
+		}
+		if got := fp.encrypted(); got != tt.enc {
+			t.Errorf("encrypted file of locked %s = %s, want it in place", tt.enc, got)
+		}
+		fp.hidden = true
+		if got := fp.encrypted(); got != tt.footprint {
+			t.Errorf("encrypted file of unlocked %s = %s, want %s", tt.enc, got, tt.footprint)
+		}
+	}
+}
+
+func TestFootprintMovesAreIdempotent(t *testing.T) {
+	for _, name := range []string{"entry.gpg", ".profile.gpg"} {
+		dir, cleanup := tempJournal(t, map[string]string{name: "encrypted"})
+		defer cleanup()
+
+		j := &Journal{RootDir: dir}
+		fp := FilePair{enc: filepath.Join(dir, name)}
+		for i := 0; i < 2; i++ {
+			if err := fp.LeaveFootprint(j); err != nil {
+				t.Fatalf("%s: leaving a footprint (attempt %d): %s", name, i+1, err)
+			}
+			if fp.EncExists() || !exists(fp.footprint()) {
+				t.Fatalf("%s: footprint not left", name)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := fp.ResetFootprint(j); err != nil {
+				t.Fatalf("%s: resetting the footprint (attempt %d): %s", name, i+1, err)
+			}
+			if !fp.EncExists() || exists(fp.footprint()) {
+				t.Fatalf("%s: footprint not reset", name)
+			}
+		}
+
+		fp.hidden = true
+		if err := fp.LeaveFootprint(j); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := fp.RemoveFootprint(j); err != nil {
+				t.Fatalf("%s: removing the footprint (attempt %d): %s", name, i+1, err)
+			}
+		}
+		if exists(fp.footprint()) || exists(fp.enc) {
+			t.Errorf("%s: encrypted file left after removing its footprint", name)
+		}
+	}
+}
+
+func TestFootprintMovesOfMissingFiles(t *testing.T) {
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+
+	j := &Journal{RootDir: dir}
+	fp := FilePair{enc: filepath.Join(dir, "missing.gpg")}
+	if err := fp.LeaveFootprint(j); err == nil {
+		t.Error("left a footprint of a missing file")
+	}
+	if err := fp.ResetFootprint(j); err == nil {
+		t.Error("reset a missing footprint")
+	}
+}
+
+func TestFilePairExists(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{"a.txt": "a", ".b.txt.gpg": "b"})
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		fp         FilePair
+		enc, plain bool
+	}{
+		{name: "plaintext only", fp: FilePair{enc: filepath.Join(dir, "a.txt.gpg"), plain: filepath.Join(dir, "a.txt")}, plain: true},
+		{name: "footprint", fp: FilePair{enc: filepath.Join(dir, "b.txt.gpg"), plain: filepath.Join(dir, "b.txt"), hidden: true}, enc: true},
+		{name: "footprint not yet left", fp: FilePair{enc: filepath.Join(dir, "b.txt.gpg"), plain: filepath.Join(dir, "b.txt")}},
+	}
+	for _, tt := range tests {
+		if got := tt.fp.EncExists(); got != tt.enc {
+			t.Errorf("%s: EncExists() = %v, want %v", tt.name, got, tt.enc)
+		}
+		if got := tt.fp.PlainExists(); got != tt.plain {
+			t.Errorf("%s: PlainExists() = %v, want %v", tt.name, got, tt.plain)
+		}
+	}
+}
+
+func TestFilePairMissingFiles(t *testing.T) {
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+
+	j := &Journal{RootDir: dir, crypter: &reverseCrypter{}}
+	fp := FilePair{enc: filepath.Join(dir, "a.txt.gpg"), plain: filepath.Join(dir, "a.txt"), hidden: true}
+	if err := fp.Decrypt(j); err == nil || !strings.Contains(err.Error(), "encrypted file "+fp.footprint()+" missing") {
+		t.Errorf("got error %v, want the missing footprint named", err)
+	}
+	if err := fp.Encrypt(j); err == nil || !strings.Contains(err.Error(), "plaintext file "+fp.plain+" missing") {
+		t.Errorf("got error %v, want the missing plaintext named", err)
+	}
+}
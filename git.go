@@ -0,0 +1,132 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// inGitWorkTree reports whether the journal directory is inside a git work
+// tree.
+func (j *Journal) inGitWorkTree() bool {
+	out, err := j.runGit("rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// commitGit commits every change in the journal directory. It does nothing
+// outside a git work tree or when there is nothing to commit.
+func (j *Journal) commitGit() error {
+	if !j.inGitWorkTree() {
+		return nil
+	}
+
+	if _, err := j.runGit("add", "-A", "."); err != nil {
+		return fmt.Errorf("Error staging journal changes: %s", err)
+	}
+
+	if _, err := j.runGit("diff", "--cached", "--quiet", "--", "."); err == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf("journal: lock %s", time.Now().Format(time.RFC3339))
+	if _, err := j.runGit("commit", "-q", "-m", msg, "--", "."); err != nil {
+		return fmt.Errorf("Error committing journal changes: %s", err)
+	}
+
+	fmt.Println("Committed journal changes to git")
+	return nil
+}
+
+// History writes the commits to the encrypted file of the entry name to w,
+// one per line, following it through renames.
+func (j *Journal) History(name string, w io.Writer) error {
+	rel, err := j.gitPath(name)
+	if err != nil {
+		return err
+	}
+
+	out, err := j.runGit("log", "--oneline", "--follow", "--", rel)
+	if err != nil {
+		return fmt.Errorf("Error reading history of %s: %s", rel, err)
+	}
+	if out == "" {
+		return fmt.Errorf("Error: %s has not been committed to git", rel)
+	}
+
+	_, err = io.WriteString(w, out)
+	return err
+}
+
+// ShowRevision decrypts the entry name as it was committed in rev to w. The
+// encrypted revision is staged in a temporary file, but its plaintext is
+// never written to disk.
+func (j *Journal) ShowRevision(name, rev string, w io.Writer) error {
+	rel, err := j.gitPath(name)
+	if err != nil {
+		return err
+	}
+
+	content, err := j.runGit("show", rev+":./"+filepath.ToSlash(rel))
+	if err != nil {
+		return fmt.Errorf("Error reading %s at %s: %s", rel, rev, err)
+	}
+
+	tmp, err := ioutil.TempFile(j.tempDir, "journal-*"+j.encryptedFileExt)
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.WriteString(tmp, content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing temporary file: %s", err)
+	}
+
+	ctx, cancel := j.context()
+	defer cancel()
+	if err := j.crypter.DecryptTo(ctx, tmp.Name(), w); err != nil {
+		return fmt.Errorf("Error decrypting %s at %s: %s", rel, rev, err)
+	}
+	return nil
+}
+
+// gitPath returns the path of the encrypted file of the entry name relative
+// to the journal root, as it is committed, after checking that the journal
+// is in a git work tree.
+func (j *Journal) gitPath(name string) (string, error) {
+	if !j.inGitWorkTree() {
+		return "", fmt.Errorf("Error: journal %s is not in a git work tree, so has no history", j.RootDir)
+	}
+
+	file, err := j.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return j.relPath(file.enc), nil
+}
+
+// runGit runs git with args in the journal directory and returns its output.
+func (j *Journal) runGit(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = j.RootDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", err, msg)
+		}
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
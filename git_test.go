@@ -0,0 +1,153 @@
+package journal
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// gitInit makes the test journal a git repository, skipping t if git is not
+// installed.
+func (tj *testJournal) gitInit(t testing.TB) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "Journal Test"},
+		{"config", "user.email", "journal@example.com"},
+		{"config", "commit.gpgsign", "false"},
+	} {
+		tj.git(t, args...)
+	}
+}
+
+// git runs git with args in the test journal and returns its output.
+func (tj *testJournal) git(t testing.TB, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = tj.dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func TestLockCommitsToGit(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+	tj.gitInit(t)
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a edited")
+	if err := tj.open(t, Options{Git: true}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tj.git(t, "log", "--format=%s"); !strings.HasPrefix(got, "journal: lock ") || strings.Count(got, "\n") != 1 {
+		t.Errorf("got commits %q, want one journal lock commit", got)
+	}
+	files := strings.Fields(tj.git(t, "ls-files"))
+	committed := pathSet(files)
+	for _, name := range []string{"a.txt.gpg", "b.txt.gpg", ".gpgid"} {
+		if !committed[name] {
+			t.Errorf("%s not committed, committed %v", name, files)
+		}
+	}
+	for _, name := range []string{"a.txt", ".check"} {
+		if committed[name] {
+			t.Errorf("committed %s", name)
+		}
+	}
+	if status := tj.git(t, "status", "--porcelain"); status != "" {
+		t.Errorf("changes left uncommitted:\n%s", status)
+	}
+
+	// locking without changes commits nothing more
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{Git: true}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(tj.git(t, "log", "--format=%s"), "\n"); got != 1 {
+		t.Errorf("got %d commits after locking an unchanged journal, want 1", got)
+	}
+}
+
+func TestLockOutsideGit(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{Git: true}).Lock(); err != nil {
+		t.Errorf("got error %v locking outside a git work tree, want none", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+	tj.gitInit(t)
+
+	for _, content := range []string{"a edited", "a edited again"} {
+		if err := tj.open(t, Options{}).Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.write(t, "a.txt", content)
+		if err := tj.open(t, Options{Git: true}).Lock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tj.fake.encrypt(t, tj.path("c.txt.gpg"), "c", defaultFakeKeys[0].keyID())
+
+	j := tj.open(t, Options{})
+	var out strings.Builder
+	if err := j.History("a.txt", &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "journal: lock "); got != 2 {
+		t.Errorf("got history:\n%s\nwant both commits", out.String())
+	}
+	out.Reset()
+	if err := j.History("b.txt.gpg", &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Errorf("got history:\n%s\nwant the first commit only", out.String())
+	}
+	if err := j.History("c.txt", &out); err == nil || !strings.Contains(err.Error(), "has not been committed") {
+		t.Errorf("got error %v, want c.txt.gpg reported uncommitted", err)
+	}
+
+	for rev, want := range map[string]string{"HEAD~1": "a edited", "HEAD": "a edited again"} {
+		out.Reset()
+		if err := j.ShowRevision("a.txt", rev, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("a.txt at %s holds %q, want %q", rev, out.String(), want)
+		}
+	}
+	if err := j.ShowRevision("a.txt", "HEAD~5", &out); err == nil {
+		t.Error("showed a revision that does not exist")
+	}
+}
+
+func TestHistoryOutsideGit(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	var out strings.Builder
+	if err := tj.open(t, Options{}).History("a.txt", &out); err == nil || !strings.Contains(err.Error(), "not in a git work tree") {
+		t.Errorf("got error %v, want the journal reported outside git", err)
+	}
+}
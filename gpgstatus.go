@@ -0,0 +1,182 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors reported by gpg through its status output. The errors returned by
+// GPGCrypter are StatusErrors wrapping one of these where gpg reported one.
+var (
+	ErrNoSecretKey       = errors.New("no secret key to decrypt with")
+	ErrBadSignature      = errors.New("bad signature")
+	ErrIntegrity         = errors.New("integrity check failed, the file may have been modified")
+	ErrNoData            = errors.New("no encrypted data found")
+	ErrDecryptionFailed  = errors.New("decryption failed")
+	ErrInvalidRecipient  = errors.New("invalid recipient")
+	ErrEncryptionFailed  = errors.New("encryption failed")
+	errNoStatusOfSuccess = errors.New("gpg exited without reporting success")
+)
+
+// StatusError is a gpg failure identified by a line of its status output.
+type StatusError struct {
+	Err    error  // one of the errors above
+	Status string // the status line reporting it, less its [GNUPG:] prefix
+	cause  error  // the error gpg exited with, if any
+}
+
+func (e *StatusError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s (%s): %s", e.Err, e.Status, e.cause)
+	}
+	return fmt.Sprintf("%s (%s)", e.Err, e.Status)
+}
+
+// Unwrap returns the error gpg reported.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// DecryptError is the failure of the gpg command to decrypt a file. Err is
+// a StatusError where gpg reported why. File is not repeated in the message,
+// as callers already name the file they failed on.
+type DecryptError struct {
+	File    string   // the encrypted file, or "" for a stream
+	Command string   // the gpg command run
+	Args    []string // its arguments, recorded when verbose
+	Err     error
+}
+
+func (e *DecryptError) Error() string {
+	command := e.Command
+	if len(e.Args) > 0 {
+		command += " " + strings.Join(e.Args, " ")
+	}
+	return fmt.Sprintf("%s: %s", command, e.Err)
+}
+
+// Unwrap returns the reason decryption failed.
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// statusPrefix opens each line gpg writes to its --status-fd.
+const statusPrefix = "[GNUPG:] "
+
+// splitStatus separates the status lines gpg interleaved with its
+// diagnostics in output.
+func splitStatus(output string) (status []string, diagnostics string) {
+	var lines []string
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if strings.HasPrefix(line, statusPrefix) {
+			status = append(status, strings.TrimSpace(strings.TrimPrefix(line, statusPrefix)))
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return status, strings.Join(lines, "")
+}
+
+// statusLine returns the first of status reporting keyword.
+func statusLine(status []string, keyword string) (string, bool) {
+	for _, line := range status {
+		if line == keyword || strings.HasPrefix(line, keyword+" ") {
+			return line, true
+		}
+	}
+
+	return "", false
+}
+
+// decryptionError returns the error of a decryption that reported status
+// and exited with err. It only succeeds once gpg reports DECRYPTION_OKAY,
+// and fails on a bad signature or integrity check even then.
+func decryptionError(status []string, err error) error {
+	for _, failure := range []struct {
+		keyword string
+		err     error
+	}{
+		{"BADSIG", ErrBadSignature},
+		{"BADMDC", ErrIntegrity},
+	} {
+		if line, ok := statusLine(status, failure.keyword); ok {
+			return &StatusError{Err: failure.err, Status: line, cause: err}
+		}
+	}
+
+	if _, ok := statusLine(status, "DECRYPTION_OKAY"); ok && err == nil {
+		return nil
+	}
+
+	// NO_SECKEY is also reported for the other recipients of a file that
+	// did decrypt, so it only explains a failure
+	for _, failure := range []struct {
+		keyword string
+		err     error
+	}{
+		{"NO_SECKEY", ErrNoSecretKey},
+		{"NODATA", ErrNoData},
+		{"DECRYPTION_FAILED", ErrDecryptionFailed},
+	} {
+		if line, ok := statusLine(status, failure.keyword); ok {
+			return &StatusError{Err: failure.err, Status: line, cause: err}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	return &StatusError{Err: ErrDecryptionFailed, Status: "no DECRYPTION_OKAY", cause: errNoStatusOfSuccess}
+}
+
+// encryptionError returns the error of an encryption that reported status
+// and exited with err. It only succeeds once gpg reports END_ENCRYPTION.
+func encryptionError(status []string, err error) error {
+	if line, ok := statusLine(status, "INV_RECP"); ok {
+		return &StatusError{Err: ErrInvalidRecipient, Status: line, cause: err}
+	}
+	if line, ok := statusLine(status, "FAILURE"); ok {
+		return &StatusError{Err: ErrEncryptionFailed, Status: line, cause: err}
+	}
+
+	if err != nil {
+		return err
+	}
+	if _, ok := statusLine(status, "END_ENCRYPTION"); !ok {
+		return &StatusError{Err: ErrEncryptionFailed, Status: "no END_ENCRYPTION", cause: errNoStatusOfSuccess}
+	}
+	return nil
+}
+
+// fileErrors attributes the status output of a gpg --decrypt-files
+// invocation over n files, which exited with err, to each of them in turn by
+// the FILE_START that opens each file's part. Files gpg never reached take
+// err.
+func fileErrors(n int, status []string, err error) []error {
+	parts := make([][]string, 0, n)
+	for _, line := range status {
+		if line == "FILE_START" || strings.HasPrefix(line, "FILE_START ") {
+			parts = append(parts, nil)
+			continue
+		}
+		if len(parts) > 0 {
+			parts[len(parts)-1] = append(parts[len(parts)-1], line)
+		}
+	}
+
+	errs := make([]error, n)
+	for i := range errs {
+		switch {
+		case i < len(parts):
+			errs[i] = decryptionError(parts[i], nil)
+		case err != nil:
+			errs[i] = err
+		default:
+			errs[i] = fmt.Errorf("gpg stopped before decrypting it")
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,241 @@
+package journal
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// status lines recorded from gpg 2.2 runs
+var (
+	decryptedStatus = []string{
+		"ENC_TO AECFE6582E716793 1 0",
+		"KEY_CONSIDERED B29BAA257C4C25731BA2D262AECFE6582E716793 0",
+		"DECRYPTION_KEY 0A2B91D0F3C7E4A1836B2F7DAECFE6582E716793 B29BAA257C4C25731BA2D262AECFE6582E716793 u",
+		"BEGIN_DECRYPTION",
+		"DECRYPTION_COMPLIANCE_MODE 23",
+		"DECRYPTION_INFO 2 9",
+		"PLAINTEXT 62 1600000000 a.txt",
+		"PLAINTEXT_LENGTH 6",
+		"DECRYPTION_OKAY",
+		"GOODMDC",
+		"END_DECRYPTION",
+	}
+	noSecretKeyStatus = []string{
+		"ENC_TO AECFE6582E716793 1 0",
+		"NO_SECKEY AECFE6582E716793",
+		"BEGIN_DECRYPTION",
+		"DECRYPTION_FAILED",
+		"END_DECRYPTION",
+	}
+	// a file encrypted to two keys decrypts with the second
+	otherRecipientStatus = []string{
+		"ENC_TO 1111111111111111 1 0",
+		"ENC_TO AECFE6582E716793 1 0",
+		"NO_SECKEY 1111111111111111",
+		"BEGIN_DECRYPTION",
+		"DECRYPTION_OKAY",
+		"GOODMDC",
+		"END_DECRYPTION",
+	}
+	noDataStatus = []string{
+		"NODATA 1",
+		"NODATA 2",
+		"FAILURE decrypt 4294967295",
+	}
+	badMDCStatus = []string{
+		"ENC_TO AECFE6582E716793 1 0",
+		"BEGIN_DECRYPTION",
+		"DECRYPTION_OKAY",
+		"BADMDC",
+		"END_DECRYPTION",
+	}
+	encryptedStatus = []string{
+		"KEY_CONSIDERED B29BAA257C4C25731BA2D262AECFE6582E716793 0",
+		"BEGIN_ENCRYPTION 2 9",
+		"END_ENCRYPTION",
+	}
+	invalidRecipientStatus = []string{
+		"KEY_CONSIDERED B29BAA257C4C25731BA2D262AECFE6582E716793 0",
+		"INV_RECP 10 someone@example.com",
+		"FAILURE encrypt 53",
+	}
+)
+
+func TestSplitStatus(t *testing.T) {
+	output := "gpg: encrypted with rsa3072 key, ID AECFE6582E716793\n" +
+		"[GNUPG:] ENC_TO AECFE6582E716793 1 0\n" +
+		"gpg: decryption failed: No secret key\n" +
+		"[GNUPG:] DECRYPTION_FAILED\r\n"
+
+	status, diagnostics := splitStatus(output)
+	if want := []string{"ENC_TO AECFE6582E716793 1 0", "DECRYPTION_FAILED"}; strings.Join(status, "|") != strings.Join(want, "|") {
+		t.Errorf("got status %q, want %q", status, want)
+	}
+	if want := "gpg: encrypted with rsa3072 key, ID AECFE6582E716793\ngpg: decryption failed: No secret key\n"; diagnostics != want {
+		t.Errorf("got diagnostics %q, want %q", diagnostics, want)
+	}
+}
+
+func TestDecryptionError(t *testing.T) {
+	exitErr := errors.New("exit status 2")
+
+	tests := []struct {
+		name   string
+		status []string
+		err    error
+		want   error // nil for success
+		line   string
+	}{
+		{name: "decrypted", status: decryptedStatus},
+		{name: "decrypted by another recipient's key", status: otherRecipientStatus},
+		{name: "no secret key", status: noSecretKeyStatus, err: exitErr, want: ErrNoSecretKey, line: "NO_SECKEY AECFE6582E716793"},
+		{name: "not encrypted", status: noDataStatus, err: exitErr, want: ErrNoData, line: "NODATA 1"},
+		{name: "modified", status: badMDCStatus, want: ErrIntegrity, line: "BADMDC"},
+		{name: "bad signature", status: append([]string{"BADSIG 1111111111111111 someone"}, decryptedStatus...), want: ErrBadSignature, line: "BADSIG 1111111111111111 someone"},
+		{name: "exit status despite DECRYPTION_OKAY", status: decryptedStatus, err: exitErr, want: exitErr},
+		{name: "no status of success", status: []string{"BEGIN_DECRYPTION"}, want: ErrDecryptionFailed, line: "no DECRYPTION_OKAY"},
+		{name: "no status at all", err: exitErr, want: exitErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decryptionError(tt.status, tt.err)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+
+			var serr *StatusError
+			if tt.line == "" {
+				if errors.As(err, &serr) {
+					t.Errorf("got status error %v, want %v as it is", err, tt.want)
+				}
+				return
+			}
+			if !errors.As(err, &serr) || serr.Status != tt.line {
+				t.Errorf("got error %v, want a status error reporting %q", err, tt.line)
+			}
+		})
+	}
+}
+
+func TestEncryptionError(t *testing.T) {
+	exitErr := errors.New("exit status 2")
+
+	tests := []struct {
+		name   string
+		status []string
+		err    error
+		want   error
+	}{
+		{name: "encrypted", status: encryptedStatus},
+		{name: "invalid recipient", status: invalidRecipientStatus, err: exitErr, want: ErrInvalidRecipient},
+		{name: "failure", status: []string{"FAILURE encrypt 1"}, err: exitErr, want: ErrEncryptionFailed},
+		{name: "no END_ENCRYPTION", status: []string{"BEGIN_ENCRYPTION 2 9"}, want: ErrEncryptionFailed},
+		{name: "exit status alone", err: exitErr, want: exitErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := encryptionError(tt.status, tt.err)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	exitErr := errors.New("exit status 2")
+	part := func(name string, status []string) []string {
+		return append([]string{"FILE_START 3 " + name}, append(status, "FILE_DONE")...)
+	}
+
+	tests := []struct {
+		name   string
+		n      int
+		status []string
+		err    error
+		want   []error // nil entries decrypted
+	}{
+		{
+			name:   "all decrypted",
+			n:      2,
+			status: append(part("a.txt.gpg", decryptedStatus), part("b.txt.gpg", otherRecipientStatus)...),
+			want:   []error{nil, nil},
+		},
+		{
+			name:   "one without a secret key",
+			n:      3,
+			status: append(append(part("a.txt.gpg", decryptedStatus), part("b.txt.gpg", noSecretKeyStatus)...), part("c.txt.gpg", decryptedStatus)...),
+			err:    exitErr,
+			want:   []error{nil, ErrNoSecretKey, nil},
+		},
+		{
+			name:   "stopped early",
+			n:      3,
+			status: part("a.txt.gpg", decryptedStatus),
+			err:    exitErr,
+			want:   []error{nil, exitErr, exitErr},
+		},
+		{
+			name:   "stopped early without an error",
+			n:      2,
+			status: part("a.txt.gpg", decryptedStatus),
+			want:   []error{nil, errors.New("gpg stopped before decrypting it")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := fileErrors(tt.n, tt.status, tt.err)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d errors, want %d", len(errs), len(tt.want))
+			}
+			for i, want := range tt.want {
+				switch {
+				case want == nil && errs[i] != nil:
+					t.Errorf("file %d: got error %v, want none", i, errs[i])
+				case want != nil && errs[i] == nil:
+					t.Errorf("file %d: got no error, want %v", i, want)
+				case want != nil && !errors.Is(errs[i], want) && errs[i].Error() != want.Error():
+					t.Errorf("file %d: got error %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecryptErrorUnwraps(t *testing.T) {
+	cause := &exec.ExitError{}
+	err := error(&DecryptError{
+		File:    "a.txt.gpg",
+		Command: "gpg",
+		Args:    []string{"-d", "--batch", "a.txt.gpg"},
+		Err:     decryptionError(noSecretKeyStatus, cause),
+	})
+
+	var derr *DecryptError
+	if !errors.As(err, &derr) || derr.File != "a.txt.gpg" {
+		t.Fatalf("got %v, want a DecryptError for a.txt.gpg", err)
+	}
+	if !errors.Is(err, ErrNoSecretKey) {
+		t.Errorf("got %v, want it to wrap ErrNoSecretKey", err)
+	}
+	if !strings.HasPrefix(err.Error(), "gpg -d --batch a.txt.gpg: ") {
+		t.Errorf("got message %q, want it to name the command and its arguments", err.Error())
+	}
+}
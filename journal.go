@@ -0,0 +1,2430 @@
+// Package journal keeps a directory of text files encrypted with gpg or age,
+// decrypting them in place to be edited and encrypting them again afterwards.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultFileExt is the default extension of encrypted files.
+var DefaultFileExt = ".gpg"
+
+// AgeFileExt is the default extension of encrypted files with the age
+// backend.
+var AgeFileExt = ".age"
+
+// EntryDateLayout is the time layout used to name new entries.
+var EntryDateLayout = "2006-01-02"
+
+// IgnoreFile names the optional file in a journal directory listing globs,
+// one per line, of files that are not part of the journal.
+var IgnoreFile = ".journalignore"
+
+// ArmorFileExt is the default extension of encrypted files in armor mode.
+var ArmorFileExt = ".asc"
+
+// ErrNotInitialised is returned by Open for a gpg journal without recipients.
+var ErrNotInitialised = errors.New("Journal directory is not initialised. Run journal init.")
+
+// ErrNoAgeRecipients is returned by Open for an age journal without an
+// AgeRecipientsFile.
+var ErrNoAgeRecipients = errors.New("Journal directory has no " + AgeRecipientsFile + " file. Create one to use the age backend.")
+
+// IsJournal reports whether dir holds the recipients or config of a
+// journal: a .gpgid, AgeRecipientsFile or ConfigFile.
+func IsJournal(dir string) bool {
+	if gpgidPath(dir) != "" {
+		return true
+	}
+	for _, name := range []string{AgeRecipientsFile, ConfigFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nonHiddenFilesFilter excludes dotfiles, which covers .check, .gpgid and the
+// footprints of encrypted files.
+func nonHiddenFilesFilter(path string, _ os.FileInfo) bool {
+	return !strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// Entry is the machine readable description of a journal file printed by
+// status and list with --json. Path is the file on disk, relative to the
+// journal root, and Name the entry it holds. Modified is unset for deleted
+// files.
+type Entry struct {
+	Path     string     `json:"path"`
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// Options configure a journal opened with Open. Zero values select the
+// defaults, or the settings of the journal's ConfigFile where it has them.
+type Options struct {
+	// Backend is gpg or age. Empty selects age for a directory with only an
+	// AgeRecipientsFile, and gpg otherwise.
+	Backend     string
+	GPGCommand  string // gpg binary, "gpg" by default
+	GPGHome     string // GnuPG home directory, in place of GNUPGHOME
+	AgeCommand  string // age binary, "age" by default
+	AgeIdentity string // age identity file used to decrypt
+
+	// Ext is the extension of encrypted files. Empty selects DefaultFileExt,
+	// or AgeFileExt or ArmorFileExt to suit the backend.
+	Ext string
+
+	// PlainExt is given to plaintext that would otherwise have no
+	// extension, so entry.gpg unlocks to entry.txt, and taken off again
+	// when it is encrypted.
+	PlainExt string
+
+	// Recipients take the place of the journal's .gpgid, as do those listed
+	// in RecipientFile, which is read like a .gpgid.
+	Recipients    []string
+	RecipientFile string
+	EncryptToSelf bool
+
+	// Armor and Compress, which gzips plaintext before encrypting it with
+	// gpg, are left nil to take the config's setting.
+	Armor    *bool
+	Compress *bool
+
+	// StrictRecipients fails encryption to a recipient that does not name
+	// exactly one key.
+	StrictRecipients bool
+
+	// Interactive lets gpg prompt while decrypting, as a smartcard may need
+	// it to, by leaving out --batch. Unlock then decrypts one file at a
+	// time so that prompts do not interleave.
+	Interactive bool
+
+	// Symmetric encrypts with a passphrase instead of gpg keys. Passphrase
+	// is that passphrase, or gpg prompts for it through its agent.
+	Symmetric      bool
+	Passphrase     string
+	PassphraseFile string
+
+	// Ignore holds globs of files to leave out of the journal, in addition
+	// to those in IgnoreFile.
+	Ignore []string
+
+	// ExcludeExt holds extensions, such as .sig, of files to leave out of
+	// the journal: foo.sig.gpg is skipped, and foo.sig is not locked.
+	ExcludeExt []string
+
+	// FollowSymlinks takes symlinked encrypted files as the files they
+	// point to within the journal. They are skipped otherwise.
+	FollowSymlinks bool
+
+	// TopLevel limits the journal to the files directly in its root,
+	// leaving those in subdirectories alone.
+	TopLevel bool
+
+	// Jobs is the number of files Unlock decrypts at once, runtime.NumCPU()
+	// by default.
+	Jobs int
+
+	// BatchSize is the number of files Unlock hands to each gpg invocation,
+	// using gpg --decrypt-files. Files it cannot batch, and any with a
+	// BatchSize below 2, are decrypted one per invocation.
+	BatchSize int
+
+	// Force lets Unlock decrypt over the plaintext of an unlocked journal.
+	// Keep and NoFootprint make Unlock leave encrypted files in place, or
+	// delete them, rather than move them to footprints.
+	Force       bool
+	Keep        bool
+	NoFootprint bool
+
+	// ConfirmAbove is the number of modified or deleted files above which
+	// Lock calls Confirm before locking them, so that a mishap that changed
+	// every file is not baked into the encrypted files. Lock refuses to
+	// continue if Confirm is nil or returns an error. Zero never asks.
+	ConfirmAbove int
+	Confirm      func(modified, deleted []string) error
+
+	// KeepGoing makes Unlock and Lock carry on past files that fail,
+	// leaving those files as they were, and report the failures at the end.
+	KeepGoing bool
+
+	// Since limits Unlock to entries dated on or after it, by the date their
+	// name starts with, laid out as DateLayout (EntryDateLayout by default),
+	// or else by the modification time of their encrypted file.
+	Since      time.Time
+	DateLayout string
+
+	// Only limits Unlock to the entries matching one of these globs,
+	// relative to the journal root, by their encrypted or plaintext path.
+	Only []string
+
+	// Editor is the editor to run when neither $VISUAL nor $EDITOR is set.
+	Editor string
+
+	// OutDir has Unlock decrypt entries into a copy of the journal's
+	// layout under it, leaving the journal itself locked. Such a copy is
+	// read-only: changes to it are never locked back into the journal.
+	OutDir string
+
+	// TempDir holds the plaintext that edit and new decrypt or write
+	// before encrypting it, os.TempDir() by default. A tmpfs keeps it off
+	// disk.
+	TempDir string
+
+	Shred   bool          // overwrite plaintext before removing it
+	Git     bool          // commit the journal after Lock
+	DryRun  bool          // print what would be done without doing it
+	Timeout time.Duration // limit on each gpg invocation
+	Retries int           // retries of gpg after a transient agent failure
+	Verbose bool          // print each gpg invocation
+}
+
+type Journal struct {
+	RootDir string
+	Files   []FilePair
+
+	encryptedFileExt string
+	crypter          Crypter
+	shred            bool
+	jobs             int
+	batchSize        int
+	force            bool
+	keep             bool
+	noFootprint      bool
+	dryRun           bool
+	git              bool
+	ignore           []string
+	excludeExt       []string
+	timeout          time.Duration
+	since            time.Time
+	dateLayout       string
+	keepGoing        bool
+	confirmAbove     int
+	confirm          func(modified, deleted []string) error
+	tempDir          string
+	outDir           string
+	followSymlinks   bool
+	topLevel         bool
+	plainExt         string
+	only             []string
+	editor           string
+}
+
+// Open reads the journal in dir with opts.
+func Open(dir string, opts Options) (*Journal, error) {
+	rootDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %s is not a valid path: %s", dir, err)
+	}
+
+	if err := applyConfig(rootDir, &opts); err != nil {
+		return nil, err
+	}
+
+	journal := &Journal{
+		RootDir:          rootDir,
+		encryptedFileExt: opts.Ext,
+		shred:            opts.Shred,
+		jobs:             opts.Jobs,
+		batchSize:        opts.BatchSize,
+		force:            opts.Force,
+		keep:             opts.Keep,
+		noFootprint:      opts.NoFootprint,
+		dryRun:           opts.DryRun,
+		git:              opts.Git,
+		ignore:           append([]string(nil), opts.Ignore...),
+		excludeExt:       excludeExts(opts.ExcludeExt),
+		timeout:          opts.Timeout,
+		since:            opts.Since,
+		dateLayout:       opts.DateLayout,
+		keepGoing:        opts.KeepGoing,
+		confirmAbove:     opts.ConfirmAbove,
+		confirm:          opts.Confirm,
+		tempDir:          opts.TempDir,
+		outDir:           opts.OutDir,
+		followSymlinks:   opts.FollowSymlinks,
+		topLevel:         opts.TopLevel,
+		plainExt:         opts.PlainExt,
+		only:             append([]string(nil), opts.Only...),
+		editor:           opts.Editor,
+	}
+	if journal.jobs < 1 {
+		journal.jobs = runtime.NumCPU()
+	}
+	if opts.Interactive {
+		journal.jobs = 1
+	}
+
+	ignorefile, err := ioutil.ReadFile(filepath.Join(rootDir, IgnoreFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading %s: %s", IgnoreFile, err)
+	}
+	for _, line := range strings.Split(string(ignorefile), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			journal.ignore = append(journal.ignore, line)
+		}
+	}
+	for _, pattern := range journal.ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Error: invalid ignore pattern %q: %s", pattern, err)
+		}
+	}
+	for _, pattern := range journal.only {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Error: invalid pattern %q: %s", pattern, err)
+		}
+	}
+
+	// without an explicit backend, use age only for directories set up for it
+	ageRecipients := filepath.Join(journal.RootDir, AgeRecipientsFile)
+	backend := opts.Backend
+	if backend == "" {
+		backend = "gpg"
+		_, ageErr := os.Stat(ageRecipients)
+		if gpgidPath(journal.RootDir) == "" && ageErr == nil {
+			backend = "age"
+		}
+	}
+
+	switch backend {
+	case "gpg":
+		journal.crypter, err = newGPGCrypter(journal.RootDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		if isSet(opts.Armor) && journal.encryptedFileExt == "" {
+			journal.encryptedFileExt = ArmorFileExt
+		}
+	case "age":
+		if opts.Passphrase != "" || opts.PassphraseFile != "" {
+			return nil, fmt.Errorf("Error: a passphrase is not used by the age backend, which takes an --age-identity")
+		}
+		if _, err := os.Stat(ageRecipients); os.IsNotExist(err) {
+			return nil, ErrNoAgeRecipients
+		}
+		journal.crypter = &AgeCrypter{
+			Command:        opts.AgeCommand,
+			RecipientsFile: ageRecipients,
+			Identity:       opts.AgeIdentity,
+			Armor:          isSet(opts.Armor),
+			Verbose:        opts.Verbose,
+		}
+		if journal.encryptedFileExt == "" {
+			journal.encryptedFileExt = AgeFileExt
+		}
+	default:
+		return nil, fmt.Errorf("Error: unknown backend %s", backend)
+	}
+
+	if journal.encryptedFileExt == "" {
+		journal.encryptedFileExt = DefaultFileExt
+	}
+	if !strings.HasPrefix(journal.encryptedFileExt, ".") {
+		journal.encryptedFileExt = "." + journal.encryptedFileExt
+	}
+	if journal.plainExt != "" && !strings.HasPrefix(journal.plainExt, ".") {
+		journal.plainExt = "." + journal.plainExt
+	}
+
+	if journal.tempDir != "" {
+		if err := checkTempDir(journal.tempDir); err != nil {
+			return nil, err
+		}
+	}
+
+	journal.Files, err = journal.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// checkTempDir reports an error unless dir is a directory temporary files
+// can be created in.
+func checkTempDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Error: temporary directory %s: %s", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Error: temporary directory %s is not a directory", dir)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "journal-*")
+	if err != nil {
+		return fmt.Errorf("Error: temporary directory %s is not writable: %s", dir, err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// applyConfig fills in the options left unset from the config file in dir,
+// and the defaults of those still unset.
+func applyConfig(dir string, opts *Options) error {
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		return err
+	}
+
+	if opts.GPGCommand == "" {
+		opts.GPGCommand = cfg.GPG
+	}
+	if opts.Ext == "" {
+		opts.Ext = cfg.Ext
+	}
+	if opts.PlainExt == "" {
+		opts.PlainExt = cfg.PlainExt
+	}
+	if opts.Armor == nil {
+		opts.Armor = &cfg.Armor
+	}
+	if opts.Compress == nil {
+		opts.Compress = &cfg.Compress
+	}
+	if len(opts.Recipients) == 0 && opts.RecipientFile == "" {
+		opts.Recipients = cfg.Recipients
+	}
+	if opts.DateLayout == "" {
+		opts.DateLayout = cfg.DateLayout
+	}
+	if opts.TempDir == "" {
+		opts.TempDir = cfg.TempDir
+	}
+	if opts.Editor == "" {
+		opts.Editor = cfg.Editor
+	}
+
+	if opts.GPGCommand == "" {
+		opts.GPGCommand = "gpg"
+	}
+	if opts.AgeCommand == "" {
+		opts.AgeCommand = "age"
+	}
+	if opts.DateLayout == "" {
+		opts.DateLayout = EntryDateLayout
+	}
+
+	return nil
+}
+
+// isSet reports whether an option left nil for the config is set.
+func isSet(option *bool) bool {
+	return option != nil && *option
+}
+
+// newGPGCrypter configures gpg from opts and the .gpgid in dir.
+func newGPGCrypter(dir string, opts Options) (*GPGCrypter, error) {
+	gpg := &GPGCrypter{
+		Command:          opts.GPGCommand,
+		Recipients:       opts.Recipients,
+		Symmetric:        opts.Symmetric,
+		Armor:            isSet(opts.Armor),
+		Passphrase:       opts.Passphrase,
+		PassphraseFile:   opts.PassphraseFile,
+		Homedir:          opts.GPGHome,
+		Compress:         isSet(opts.Compress),
+		Retries:          opts.Retries,
+		EncryptToSelf:    opts.EncryptToSelf,
+		StrictRecipients: opts.StrictRecipients,
+		Interactive:      opts.Interactive,
+		Verbose:          opts.Verbose,
+	}
+	if gpg.Passphrase != "" && gpg.PassphraseFile != "" {
+		return nil, fmt.Errorf("Error: a passphrase and a passphrase file cannot be used together")
+	}
+
+	if len(gpg.Recipients) == 0 && opts.RecipientFile != "" {
+		recipients, err := readRecipientFile(opts.RecipientFile)
+		if err != nil {
+			return nil, err
+		}
+		gpg.Recipients = recipients
+	}
+
+	// configured recipients take the place of .gpgid
+	if len(gpg.Recipients) == 0 {
+		gpgid := gpgidPath(dir)
+		if gpgid == "" && !gpg.Symmetric {
+			return nil, ErrNotInitialised
+		}
+		if gpgid != "" {
+			content, err := ioutil.ReadFile(gpgid)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading %s: %s", gpgid, err)
+			}
+			gpg.Recipients = parseRecipients(content)
+		}
+
+		// subdirectories may name their own recipients
+		gpg.RootDir = dir
+	}
+
+	return gpg, nil
+}
+
+// readRecipientFile reads the recipients listed in path like a .gpgid,
+// failing if it lists none.
+func readRecipientFile(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading recipient file: %s", err)
+	}
+	recipients := parseRecipients(content)
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("Error: recipient file %s lists no recipients", path)
+	}
+
+	return recipients, nil
+}
+
+// InitRecipients returns the recipients a journal initialised in dir is
+// encrypted to: opts.Recipients, else those listed in opts.RecipientFile,
+// else those of the .journal config in dir. It returns none if none of
+// them name any.
+func InitRecipients(dir string, opts Options) ([]string, error) {
+	if len(opts.Recipients) > 0 {
+		return opts.Recipients, nil
+	}
+	if opts.RecipientFile != "" {
+		return readRecipientFile(opts.RecipientFile)
+	}
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Recipients, nil
+}
+
+// SecretKeyRecipient returns the fingerprint of the only secret key gpg
+// holds, to initialise a journal for a user with a single key. It fails if
+// there are none or several to choose from.
+func SecretKeyRecipient(opts Options) (string, error) {
+	command := opts.GPGCommand
+	if command == "" {
+		command = "gpg"
+	}
+
+	gpg := &GPGCrypter{Command: command, Homedir: opts.GPGHome, Verbose: opts.Verbose}
+	fpr, err := gpg.onlySecretKey(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("Error: %s", err)
+	}
+	return fpr, nil
+}
+
+// InitJournal writes the recipients InitRecipients finds to the .gpgid file
+// in dir, creating dir if needed. An existing .gpgid is only replaced when
+// force is set.
+func InitJournal(dir string, opts Options, force bool) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Error creating journal directory: %s", err)
+	}
+
+	if opts.Symmetric {
+		fmt.Printf("Initialised journal in %s for symmetric encryption\n", dir)
+		return nil
+	}
+
+	gpgid := filepath.Join(dir, ".gpgid")
+	if _, err := os.Stat(gpgid); err == nil && !force {
+		return fmt.Errorf("Journal directory %s is already initialised. Use --force to overwrite .gpgid", dir)
+	}
+
+	command := opts.GPGCommand
+	if command == "" {
+		command = "gpg"
+	}
+	recipients, err := InitRecipients(dir, opts)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("Error: no recipient given. Pass --recipient or --auto-recipient")
+	}
+
+	gpg := &GPGCrypter{Command: command, Recipients: recipients, Homedir: opts.GPGHome, Verbose: opts.Verbose}
+	if err := gpg.CheckRecipients(context.Background()); err != nil {
+		return fmt.Errorf("Error: %s", err)
+	}
+
+	content := strings.Join(recipients, "\n") + "\n"
+	if err := ioutil.WriteFile(gpgid, []byte(content), 0600); err != nil {
+		return fmt.Errorf("Error writing .gpgid: %s", err)
+	}
+
+	fmt.Printf("Initialised journal in %s for %s\n", dir, strings.Join(recipients, ", "))
+	return nil
+}
+
+func (j *Journal) Unlock() error {
+	if j.keep && j.noFootprint {
+		return fmt.Errorf("Error: --keep and --no-footprint cannot be used together")
+	}
+	if err := j.checkOutDir(); err != nil {
+		return err
+	}
+
+	release, err := j.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// decrypting again would overwrite any edits made since the last unlock
+	var previous *Checklist
+	if j.unlocked() && j.outDir == "" {
+		if !j.force {
+			return fmt.Errorf("Journal %s is already unlocked; lock it first, or pass --force to discard changes to the plaintext", j.RootDir)
+		}
+		fmt.Printf("Warning: journal %s is already unlocked; overwriting its plaintext\n", j.RootDir)
+		if previous, err = j.readChecklist(); err != nil {
+			return err
+		}
+	}
+
+	// entries dated before --since, or not among those asked for, stay
+	// locked. Lock leaves them alone as they have no footprint.
+	var unlock []int
+	for i, file := range j.Files {
+		if len(j.only) > 0 && !j.selected(file) {
+			continue
+		}
+		if !j.since.IsZero() {
+			date, err := j.entryDate(file)
+			if err != nil {
+				return fmt.Errorf("Error reading date of %s: %s", file.encrypted(), err)
+			}
+			if date.Before(j.since) {
+				continue
+			}
+		}
+		unlock = append(unlock, i)
+	}
+	if len(j.only) > 0 && len(unlock) == 0 {
+		return fmt.Errorf("Error: no entries in %s match %s", j.RootDir, strings.Join(j.only, " "))
+	}
+	if j.outDir != "" {
+		return j.unlockTo(unlock)
+	}
+
+	// a footprint moved over an existing file would lose it: a hidden entry
+	// (.foo.gpg beside foo.gpg), or a stale footprint clean can remove
+	if !j.noFootprint {
+		for _, i := range unlock {
+			file := j.Files[i]
+			if !file.hidden && exists(file.footprint()) {
+				return fmt.Errorf("Error: cannot unlock %s, as its footprint %s already exists; run journal clean if it is stale, or rename it if it is an entry", j.relPath(file.enc), j.relPath(file.footprint()))
+			}
+		}
+	}
+
+	// the encrypted files are as they were last written, so a journal that
+	// has never been locked records them before they are moved aside
+	if !j.unlocked() {
+		if err := j.seedEncChecklist(); err != nil {
+			return err
+		}
+	}
+
+	// decrypt with a bounded pool of workers, dispatching no further files
+	// once one has failed unless asked to keep going
+	var (
+		results   = &Results{}
+		work      = make(chan []FilePair)
+		failed    int32
+		decrypted int32
+		wg        sync.WaitGroup
+	)
+	for w := 0; w < j.jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				errs := j.unlockBatch(batch)
+				for i, file := range batch {
+					results.Record(j.relPath(file.enc), errs[i])
+					if errs[i] != nil {
+						atomic.StoreInt32(&failed, 1)
+					} else {
+						atomic.AddInt32(&decrypted, 1)
+					}
+				}
+			}
+		}()
+	}
+	for _, batch := range j.batches(unlock) {
+		if atomic.LoadInt32(&failed) != 0 && !j.keepGoing {
+			break
+		}
+		work <- batch
+	}
+	close(work)
+	wg.Wait()
+
+	// report the first failing file in walk order, or with --keep-going
+	// every failure once the other files are unlocked
+	var failures []FilePair
+	var firstErr error
+	for _, file := range j.Files {
+		err := results.Err(j.relPath(file.enc))
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if j.keepGoing {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		failures = append(failures, file)
+	}
+	unlockErr := unlockFailures(results)
+	if !j.keepGoing && firstErr != nil {
+		unlockErr = firstErr
+	}
+
+	// nothing was decrypted, so the journal is as it was once the failed
+	// files' plaintext is gone
+	if decrypted == 0 && previous == nil && firstErr != nil {
+		if err := j.removeFailed(failures, nil); err != nil {
+			return err
+		}
+		return unlockErr
+	}
+
+	// the files decrypted before a failure are left unlocked, with a
+	// checklist for lock to lock them again
+	if j.dryRun {
+		fmt.Printf("Would write checklist %s\n", filepath.Join(j.RootDir, ".check"))
+		return unlockErr
+	}
+
+	checklist, err := ChecklistFromDir(j.RootDir, j.entryFilter())
+	if err != nil {
+		return fmt.Errorf("Error reading checklist from dir: %s", err)
+	}
+
+	// files unlocked before and not decrypted again keep the hashes
+	// recorded then, so that changes made to them since are still locked
+	if previous != nil {
+		decrypted := make(map[string]bool)
+		for _, i := range unlock {
+			decrypted[j.Files[i].plain] = true
+		}
+		checklist.carryOver(previous, decrypted)
+	}
+
+	if err := j.removeFailed(failures, checklist); err != nil {
+		return err
+	}
+	if err := j.writeChecklist(checklist); err != nil {
+		return err
+	}
+	if !j.keepGoing && firstErr != nil {
+		fmt.Fprintf(os.Stderr, "Unlock stopped after decrypting %d files; run journal lock to lock them again\n", decrypted)
+	}
+
+	return unlockErr
+}
+
+// removeFailed removes the plaintext that failures, files that failed to
+// unlock, may have left, along with their entries in checklist if given.
+// They stay locked, so the plaintext must not be locked back over their
+// encrypted files.
+func (j *Journal) removeFailed(failures []FilePair, checklist *Checklist) error {
+	if j.dryRun {
+		return nil
+	}
+
+	for _, file := range failures {
+		if file.hidden {
+			continue
+		}
+		if checklist != nil {
+			checklist.Remove(file.plain)
+		}
+		if err := os.Remove(file.plain); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error removing %s: %s", file.plain, err)
+		}
+	}
+
+	return nil
+}
+
+// confirmLock asks the journal's confirm whether to lock the modified and
+// deleted files when there are more than confirmAbove of them.
+func (j *Journal) confirmLock(modified, deleted []FilePair) error {
+	if j.confirmAbove <= 0 || len(modified)+len(deleted) <= j.confirmAbove || j.dryRun {
+		return nil
+	}
+
+	var modifiedPaths, deletedPaths []string
+	for _, file := range modified {
+		modifiedPaths = append(modifiedPaths, j.relPath(file.plain))
+	}
+	for _, file := range deleted {
+		deletedPaths = append(deletedPaths, j.relPath(file.plain))
+	}
+
+	if j.confirm == nil {
+		return fmt.Errorf("Error: %d files modified and %d deleted, more than %d; not locking without confirmation", len(modified), len(deleted), j.confirmAbove)
+	}
+	return j.confirm(modifiedPaths, deletedPaths)
+}
+
+// checkOutDir makes the journal's out dir absolute, returning an error if it
+// is inside the journal, where its plaintext would be taken for new entries.
+func (j *Journal) checkOutDir() error {
+	if j.outDir == "" {
+		return nil
+	}
+
+	dir, err := filepath.Abs(j.outDir)
+	if err != nil {
+		return fmt.Errorf("Error: %s is not a valid path: %s", j.outDir, err)
+	}
+	j.outDir = dir
+
+	rel, err := filepath.Rel(j.RootDir, j.outDir)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Error: out dir %s must be outside the journal %s", j.outDir, j.RootDir)
+	}
+	return nil
+}
+
+// unlockTo decrypts the files of j.Files at indexes into the journal's out
+// dir, under the same paths as in the journal, which is left as it is.
+// Existing files there are only overwritten with force.
+func (j *Journal) unlockTo(indexes []int) error {
+	results := &Results{}
+	for _, i := range indexes {
+		file := j.Files[i]
+		target := filepath.Join(j.outDir, j.relPath(file.plain))
+
+		err := func() error {
+			if _, err := os.Lstat(target); err == nil && !j.force {
+				return fmt.Errorf("Error: %s already exists; pass --force to overwrite it", target)
+			}
+			if j.dryRun {
+				fmt.Printf("Would decrypt %s to %s\n", file.encrypted(), target)
+				return nil
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return fmt.Errorf("Error creating %s: %s", filepath.Dir(target), err)
+			}
+			out := FilePair{enc: file.encrypted(), plain: target}
+			if err := out.Decrypt(j); err != nil {
+				return fmt.Errorf("Error decrypting file %s: %w", file.encrypted(), err)
+			}
+			return j.copyMetadata(file.encrypted(), target)
+		}()
+
+		results.Record(j.relPath(file.enc), err)
+		if err != nil && !j.keepGoing {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if err := unlockFailures(results); err != nil {
+		return err
+	}
+	if !j.dryRun {
+		fmt.Printf("Unlocked %d files into %s, leaving %s locked\n", len(indexes), j.outDir, j.RootDir)
+	}
+	return nil
+}
+
+// selected reports whether file matches one of the patterns Unlock is
+// limited to.
+func (j *Journal) selected(file FilePair) bool {
+	for _, pattern := range j.only {
+		for _, path := range []string{file.enc, file.plain} {
+			if ok, _ := filepath.Match(pattern, j.relPath(path)); ok {
+				return true
+			}
+		}
+
+		// a directory selects the entries within it
+		for dir := filepath.Dir(j.relPath(file.enc)); dir != "."; dir = filepath.Dir(dir) {
+			if ok, _ := filepath.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// unlockFailures reports the files of results that failed to unlock.
+func unlockFailures(results *Results) error {
+	if len(results.Failed()) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Error: unlock incomplete, %s and were left encrypted", results.Summary())
+}
+
+// batches groups the files of j.Files at indexes into the batches Unlock
+// decrypts together. Files gpg cannot decrypt to their plaintext path by
+// itself are alone in their batch.
+func (j *Journal) batches(indexes []int) [][]FilePair {
+	var batches [][]FilePair
+	var batch []FilePair
+	for _, i := range indexes {
+		file := j.Files[i]
+		if j.batchSize < 2 || !j.batchable(file) {
+			batches = append(batches, []FilePair{file})
+			continue
+		}
+		batch = append(batch, file)
+		if len(batch) == j.batchSize {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// batchable reports whether gpg --decrypt-files would write the plaintext of
+// file where Unlock expects it: beside its encrypted file, named without the
+// extension.
+func (j *Journal) batchable(file FilePair) bool {
+	gpg, ok := j.crypter.(*GPGCrypter)
+	if !ok || gpg.Compress || file.hidden {
+		return false
+	}
+
+	ext := filepath.Ext(file.enc)
+	if ext != ".gpg" && ext != ".asc" && ext != ".pgp" {
+		return false
+	}
+	return file.plain == strings.TrimSuffix(file.enc, ext)
+}
+
+// unlockBatch unlocks the files of batch, decrypting them with a single gpg
+// invocation if there are several, and returns the error of each.
+func (j *Journal) unlockBatch(batch []FilePair) []error {
+	errs := make([]error, len(batch))
+	if len(batch) == 1 {
+		errs[0] = j.unlockFile(batch[0])
+		return errs
+	}
+
+	if j.dryRun {
+		for i, file := range batch {
+			errs[i] = j.unlockFile(file)
+		}
+		return errs
+	}
+
+	var files []string
+	var decrypting []int
+	for i, file := range batch {
+		if !file.EncExists() {
+			errs[i] = fmt.Errorf("Error decrypting file %s: encrypted file %s missing, cannot decrypt", file.encrypted(), file.encrypted())
+			continue
+		}
+		files = append(files, file.encrypted())
+		decrypting = append(decrypting, i)
+	}
+
+	ctx, cancel := j.context()
+	defer cancel()
+	decryptErrs := j.crypter.(*GPGCrypter).DecryptFiles(ctx, files)
+	for n, i := range decrypting {
+		file := batch[i]
+		err := decryptErrs[n]
+		if err == nil {
+			err = checkDecrypted(file.plain)
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("Error decrypting file %s: %w", file.encrypted(), err)
+			continue
+		}
+		errs[i] = j.finishUnlock(file)
+	}
+
+	return errs
+}
+
+// unlockFile decrypts f and moves its encrypted file aside.
+func (j *Journal) unlockFile(f FilePair) error {
+	if err := f.Decrypt(j); err != nil {
+		return fmt.Errorf("Error decrypting file %s: %w", f.encrypted(), err)
+	}
+
+	return j.finishUnlock(f)
+}
+
+// finishUnlock moves the encrypted file of the decrypted f aside. The
+// plaintext takes the mode and modification time of the encrypted file.
+func (j *Journal) finishUnlock(f FilePair) error {
+	if err := j.copyMetadata(f.encrypted(), f.plain); err != nil {
+		return fmt.Errorf("Error restoring metadata of %s: %s", f.plain, err)
+	}
+
+	// the plaintext is now the only copy, as asked for
+	if j.noFootprint {
+		if j.dryRun {
+			fmt.Printf("Would remove %s\n", f.encrypted())
+			return nil
+		}
+		if err := os.Remove(f.encrypted()); err != nil {
+			return fmt.Errorf("Error removing encrypted file %s: %s", f.encrypted(), err)
+		}
+		return nil
+	}
+
+	// files unlocked before already have a footprint, and kept files need
+	// none
+	if f.hidden || j.keep {
+		return nil
+	}
+	if err := f.LeaveFootprint(j); err != nil {
+		return fmt.Errorf("Error creating file footprint %s: %s", f.enc, err)
+	}
+
+	return nil
+}
+
+func (j *Journal) Lock() error {
+	if j.outDir != "" {
+		return fmt.Errorf("Error: files unlocked into an out dir are a read-only copy, and cannot be locked")
+	}
+
+	release, err := j.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if _, err := os.Stat(filepath.Join(j.RootDir, ".check")); os.IsNotExist(err) {
+		return fmt.Errorf("Journal %s is not unlocked (no .check file); nothing to lock", j.RootDir)
+	}
+
+	checklist, err := j.readChecklist()
+	if err != nil {
+		return err
+	}
+
+	// refuse to start if any file would fail to encrypt, rather than leave
+	// the journal half locked
+	ctx, cancel := j.context()
+	err = j.crypter.CheckRecipients(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("Error: cannot lock journal: %s", err)
+	}
+
+	// calculate which files have changed
+	changes, deletions, err := checklist.Diff()
+	if err != nil {
+		return fmt.Errorf("Could not calculate file changes: %s", err)
+	}
+
+	// plaintext files created while the journal was unlocked have no
+	// encrypted counterpart yet, and neither do those unlocked with
+	// --no-footprint, though they are recorded in the checklist. Only
+	// their paths are needed, Diff having hashed the recorded files.
+	current, err := scanDir(j.RootDir, j.entryFilter(), false)
+	if err != nil {
+		return fmt.Errorf("Error reading checklist from dir: %s", err)
+	}
+	newFiles, entries := pathSet(checklist.Added(current)), j.entryPaths()
+	var added, restored []FilePair
+	for _, file := range current.files {
+		if strings.HasSuffix(file.path, j.encryptedFileExt) || entries[file.path] {
+			continue
+		}
+
+		pair := FilePair{
+			enc:   j.encPath(file.path),
+			plain: file.path,
+		}
+		if newFiles[file.path] {
+			added = append(added, pair)
+		} else {
+			restored = append(restored, pair)
+		}
+	}
+
+	// sort files into those to re-encrypt, reset or remove. Files without a
+	// footprint were never unlocked and are left alone, unless they were
+	// kept in place by unlock --keep, which .check records them as. A kept
+	// file whose plaintext is gone was deleted like any other.
+	var encrypt, reset, remove []FilePair
+	kept := make(map[string]bool)
+	recorded, changed, deleted := checklist.recorded(), pathSet(changes), pathSet(deletions)
+	for _, file := range j.Files {
+		if !file.hidden && recorded[file.plain] {
+			kept[file.enc] = true
+		}
+
+		switch {
+		case !file.hidden && !kept[file.enc]:
+			continue
+		case deleted[file.plain]:
+			remove = append(remove, file)
+		case changed[file.plain]:
+			encrypt = append(encrypt, file)
+		default:
+			reset = append(reset, file)
+		}
+	}
+	if err := j.confirmLock(encrypt, remove); err != nil {
+		return err
+	}
+	encrypt = append(encrypt, restored...)
+	encrypt = append(encrypt, added...)
+
+	// encrypt everything to staging files first, so a failure leaves the
+	// journal unlocked exactly as it was. With --keep-going, files that fail
+	// are left unlocked and the rest are locked.
+	staged := make([]string, 0, len(encrypt))
+	discard := func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}
+	var failures []FilePair
+	var encrypted []FilePair
+	for _, file := range encrypt {
+		stage := FilePair{enc: file.staging(), plain: file.plain}
+		err := stage.Encrypt(j)
+		if err != nil {
+			err = fmt.Errorf("Error encrypting file %s, journal left unlocked: %s", file.plain, err)
+		} else if err = j.copyMetadata(file.plain, stage.enc); err != nil {
+			os.Remove(stage.enc)
+			err = fmt.Errorf("Error preserving metadata of %s, journal left unlocked: %s", file.plain, err)
+		}
+		if err != nil && !j.keepGoing {
+			discard()
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures = append(failures, file)
+			continue
+		}
+		staged = append(staged, stage.enc)
+		encrypted = append(encrypted, file)
+	}
+	encrypt = encrypted
+
+	// move the encrypted files into place, undoing every move made so far
+	// if one fails
+	var undo []func() error
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		discard()
+	}
+	for i, file := range encrypt {
+		file := file
+		if kept[file.enc] {
+			// move the kept original aside so a rollback can restore it
+			if err := file.LeaveFootprint(j); err != nil {
+				rollback()
+				return fmt.Errorf("Error moving %s aside, journal left unlocked: %s", file.enc, err)
+			}
+			undo = append(undo, func() error { return file.ResetFootprint(j) })
+			encrypt[i].hidden = true
+		}
+		if err := j.rename(staged[i], file.enc); err != nil {
+			rollback()
+			return fmt.Errorf("Error moving %s into place, journal left unlocked: %s", file.enc, err)
+		}
+		undo = append(undo, func() error { return os.Remove(file.enc) })
+	}
+	for _, file := range reset {
+		file := file
+		if kept[file.enc] {
+			continue
+		}
+		if err := file.ResetFootprint(j); err != nil {
+			rollback()
+			return fmt.Errorf("Error resetting %s, journal left unlocked: %s", file.enc, err)
+		}
+		undo = append(undo, func() error { return file.LeaveFootprint(j) })
+	}
+
+	// every file is now encrypted, so the footprints and plaintext can go
+	for _, file := range append(append([]FilePair{}, encrypt...), remove...) {
+		if !file.hidden {
+			continue
+		}
+		if err := file.RemoveFootprint(j); err != nil {
+			return err
+		}
+	}
+	for _, file := range remove {
+		if !kept[file.enc] {
+			continue
+		}
+		if j.dryRun {
+			fmt.Printf("Would remove %s\n", file.enc)
+		} else if err := os.Remove(file.enc); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error removing %s: %s", file.enc, err)
+		}
+	}
+
+	// drop the checklist before the plaintext: a crash in between leaves a
+	// locked journal with stray plaintext, which Check reports, rather than
+	// an unlocked one whose files seem to have been deleted. Files that
+	// failed keep their entries, leaving the journal unlocked for them.
+	if len(failures) > 0 {
+		for _, file := range append(append(append([]FilePair{}, encrypt...), reset...), remove...) {
+			checklist.Remove(file.plain)
+		}
+		if j.dryRun {
+			fmt.Printf("Would rewrite checklist %s\n", filepath.Join(j.RootDir, ".check"))
+		} else if err := j.writeChecklist(checklist); err != nil {
+			return err
+		}
+	} else if j.dryRun {
+		fmt.Printf("Would remove checklist %s\n", filepath.Join(j.RootDir, ".check"))
+	} else if err := os.Remove(filepath.Join(j.RootDir, ".check")); err != nil {
+		return fmt.Errorf("Error removing checklist file: %s", err)
+	}
+
+	for _, file := range append(append([]FilePair{}, encrypt...), reset...) {
+		if err := j.removePlain(file.plain); err != nil {
+			return err
+		}
+	}
+
+	encryptedNew := 0
+	for _, file := range encrypt {
+		if newFiles[file.plain] {
+			encryptedNew++
+		}
+	}
+	fmt.Printf("Locked journal: %d files re-encrypted, %d files reset, %d new files encrypted, %d files removed\n", len(encrypt)-encryptedNew, len(reset), encryptedNew, len(remove))
+
+	// record the encrypted files as they now are for VerifyEncrypted
+	if !j.dryRun {
+		files, err := j.discover()
+		if err != nil {
+			return fmt.Errorf("Error reading locked journal: %s", err)
+		}
+		err = j.updateEncChecklist(func(checklist *Checklist) error {
+			checklist.files = nil
+			return j.hashEncrypted(checklist, files)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("Error: %d files failed to lock and were left unlocked", len(failures))
+	}
+
+	// release first so the lock file is not committed
+	if j.git && !j.dryRun {
+		release()
+		return j.commitGit()
+	}
+
+	return nil
+}
+
+func (j *Journal) Status() error {
+	if _, err := j.Check(os.Stdout); err != nil {
+		return err
+	}
+
+	if !j.unlocked() {
+		fmt.Printf("Journal %s is locked\n", j.RootDir)
+		return nil
+	}
+
+	entries, err := j.StatusEntries()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Journal %s is unlocked\n", j.RootDir)
+	for _, entry := range entries {
+		fmt.Printf("%-10s %s\n", entry.State, entry.Path)
+	}
+
+	return nil
+}
+
+// Check looks for signs that an unlock or lock was interrupted: footprints or
+// plaintext in a locked journal, or files recorded in the .check of an
+// unlocked journal that have disappeared along with their footprints. It
+// writes a warning for each to w, with advice on putting the journal right,
+// and reports whether the journal is consistent.
+func (j *Journal) Check(w io.Writer) (bool, error) {
+	var problems []string
+	advice := "Run journal lock to finish locking it."
+
+	if !j.unlocked() {
+		for _, file := range j.Files {
+			if file.hidden {
+				problems = append(problems, fmt.Sprintf("%s is unlocked but the journal has no .check file", j.relPath(file.plain)))
+			} else if file.PlainExists() {
+				problems = append(problems, fmt.Sprintf("plaintext %s exists but the journal is locked", j.relPath(file.plain)))
+			}
+		}
+		advice = "Run journal unlock and then journal lock to encrypt it again."
+	} else {
+		checklist, err := j.readChecklist()
+		if err != nil {
+			return false, err
+		}
+		entries := j.entryPaths()
+		for _, file := range checklist.files {
+			if _, err := os.Stat(file.path); err == nil {
+				continue
+			}
+			// a deleted entry leaves its footprint, or with unlock --keep
+			// its encrypted file, for lock to remove
+			if !entries[file.path] {
+				problems = append(problems, fmt.Sprintf("%s is recorded in .check but neither it nor its footprint exists", j.relPath(file.path)))
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(w, "Warning: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "Journal %s may have been left part way through an unlock or lock. %s\n", j.RootDir, advice)
+	}
+
+	return len(problems) == 0, nil
+}
+
+// StatusEntries describes the plaintext files of an unlocked journal, each
+// new, modified, unchanged or deleted since unlock. The entries of a locked
+// journal are listed as by ListEntries.
+func (j *Journal) StatusEntries() ([]Entry, error) {
+	if !j.unlocked() {
+		return j.ListEntries()
+	}
+
+	checklist, err := j.readChecklist()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, deletions, err := checklist.Diff()
+	if err != nil {
+		return nil, fmt.Errorf("Could not calculate file changes: %s", err)
+	}
+
+	current, err := ChecklistFromDir(j.RootDir, j.entryFilter())
+	if err != nil {
+		return nil, fmt.Errorf("Error reading checklist from dir: %s", err)
+	}
+
+	added, changed := pathSet(checklist.Added(current)), pathSet(changes)
+	entries := []Entry{}
+	for _, file := range current.files {
+		state := "unchanged"
+		if added[file.path] {
+			state = "new"
+		} else if changed[file.path] {
+			state = "modified"
+		}
+
+		mtime := file.mtime
+		entries = append(entries, Entry{
+			Path:     j.relPath(file.path),
+			Name:     j.relPath(file.path),
+			State:    state,
+			Modified: &mtime,
+		})
+	}
+	for _, deleted := range deletions {
+		entries = append(entries, Entry{
+			Path:  j.relPath(deleted),
+			Name:  j.relPath(deleted),
+			State: "deleted",
+		})
+	}
+
+	return entries, nil
+}
+
+// StatusSummary counts the entries of a journal for status --format. Total
+// is the number of encrypted files and Unlocked those out as plaintext; the
+// other counts are of the files of an unlocked journal by their state.
+type StatusSummary struct {
+	Dir       string
+	Locked    bool
+	Total     int
+	Unlocked  int
+	Unchanged int
+	Modified  int
+	New       int
+	Deleted   int
+	Entries   []Entry
+}
+
+// DefaultStatusFormat is the text/template status --format=default prints.
+// The output is followed by a newline.
+var DefaultStatusFormat = "{{if .Locked}}locked{{else}}{{.Unlocked}}/{{.Total}} unlocked, {{.Modified}} modified, {{.New}} new{{end}}"
+
+// Summary counts the entries of the journal by state.
+func (j *Journal) Summary() (*StatusSummary, error) {
+	entries, err := j.StatusEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &StatusSummary{
+		Dir:     j.RootDir,
+		Locked:  !j.unlocked(),
+		Total:   len(j.Files),
+		Entries: entries,
+	}
+	for _, file := range j.Files {
+		if j.fileState(file) == "unlocked" {
+			summary.Unlocked++
+		}
+	}
+	if summary.Locked {
+		return summary, nil
+	}
+	for _, entry := range entries {
+		switch entry.State {
+		case "unchanged":
+			summary.Unchanged++
+		case "modified":
+			summary.Modified++
+		case "new":
+			summary.New++
+		case "deleted":
+			summary.Deleted++
+		}
+	}
+
+	return summary, nil
+}
+
+// Edit decrypts the encrypted file at name to a temporary file, opens it in
+// the editor and re-encrypts it when the editor exits successfully. The
+// temporary plaintext is shredded afterwards.
+func (j *Journal) Edit(name string) error {
+	file, err := j.lookup(name)
+	if err != nil {
+		return err
+	}
+	if file.hidden {
+		return fmt.Errorf("Error: journal is unlocked, edit %s directly", file.plain)
+	}
+
+	tmp, err := ioutil.TempFile(j.tempDir, "journal-*"+filepath.Ext(file.plain))
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %s", err)
+	}
+	tmp.Close()
+	defer shred(tmp.Name())
+
+	scratch := FilePair{enc: file.enc, plain: tmp.Name()}
+	if err := scratch.Decrypt(j); err != nil {
+		return fmt.Errorf("Error decrypting file %s: %w", file.enc, err)
+	}
+
+	before, err := ioutil.ReadFile(scratch.plain)
+	if err != nil {
+		return err
+	}
+
+	if err := j.runEditor(scratch.plain); err != nil {
+		return fmt.Errorf("Editor exited with an error, %s left unchanged: %s", file.enc, err)
+	}
+
+	after, err := ioutil.ReadFile(scratch.plain)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+
+	if err := scratch.encryptStaged(j); err != nil {
+		return fmt.Errorf("Error encrypting file %s: %s", file.enc, err)
+	}
+
+	return j.updateEncChecklist(func(checklist *Checklist) error {
+		return j.hashEncrypted(checklist, []FilePair{scratch})
+	})
+}
+
+// Session unlocks the journal for as long as the editor runs on its directory,
+// then locks it again. Interrupts are held off until the editor exits, so
+// Ctrl-C still leaves the journal locked.
+func (j *Journal) Session() error {
+	if err := j.Unlock(); err != nil {
+		return err
+	}
+
+	// the files have moved to their footprints
+	files, err := j.discover()
+	if err != nil {
+		return fmt.Errorf("Error reading unlocked journal, journal left unlocked: %s", err)
+	}
+	j.Files = files
+
+	// the editor shares the terminal and receives the interrupt itself
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	editErr := j.runEditor(j.RootDir)
+
+	if err := j.Lock(); err != nil {
+		return fmt.Errorf("%s; journal left unlocked", err)
+	}
+	if editErr != nil {
+		return fmt.Errorf("Editor exited with an error: %s", editErr)
+	}
+
+	return nil
+}
+
+// Move renames the entry from to to. The plaintext and footprint of an
+// unlocked entry are moved together and its checklist entry updated, so lock
+// encrypts it under the new name.
+func (j *Journal) Move(from, to string) error {
+	file, err := j.lookup(from)
+	if err != nil {
+		return err
+	}
+
+	dest, err := j.resolvePath(to)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(dest, j.encryptedFileExt) {
+		dest = j.encPath(dest)
+	}
+	if rel, err := filepath.Rel(j.RootDir, dest); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("Error: %s is outside the journal %s", to, j.RootDir)
+	}
+
+	moved := FilePair{
+		enc:    dest,
+		plain:  j.plainPath(dest),
+		hidden: file.hidden,
+	}
+	for _, path := range []string{moved.enc, moved.footprint(), moved.plain} {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("Error: %s already exists", path)
+		}
+	}
+
+	if !j.dryRun {
+		if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return fmt.Errorf("Error creating %s: %s", filepath.Dir(dest), err)
+		}
+	}
+
+	var checklist *Checklist
+	if j.unlocked() {
+		if checklist, err = j.readChecklist(); err != nil {
+			return err
+		}
+	}
+
+	// a journal without an .enc-check records the entry where it was
+	// before it moves
+	if err := j.seedEncChecklist(); err != nil {
+		return err
+	}
+	if err := j.rename(file.encrypted(), moved.encrypted()); err != nil {
+		return fmt.Errorf("Error moving %s: %s", file.encrypted(), err)
+	}
+	err = j.updateEncChecklist(func(checklist *Checklist) error {
+		checklist.Rename(file.enc, moved.enc)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// a locked entry is only its encrypted file
+	if checklist == nil || !checklist.Contains(file.plain) {
+		return nil
+	}
+
+	if file.PlainExists() {
+		if err := j.rename(file.plain, moved.plain); err != nil {
+			return fmt.Errorf("Error moving %s: %s", file.plain, err)
+		}
+	}
+
+	checklist.Rename(file.plain, moved.plain)
+	if j.dryRun {
+		fmt.Printf("Would update checklist %s\n", filepath.Join(j.RootDir, ".check"))
+		return nil
+	}
+	return j.writeChecklist(checklist)
+}
+
+// Cat writes the decrypted contents of the entry name to w. Plaintext is
+// never written to disk.
+func (j *Journal) Cat(name string, w io.Writer) error {
+	file, err := j.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := j.context()
+	defer cancel()
+	if err := j.crypter.DecryptTo(ctx, file.encrypted(), w); err != nil {
+		return fmt.Errorf("Error decrypting file %s: %w", file.encrypted(), err)
+	}
+
+	return nil
+}
+
+// lookup returns the file of the entry name, given with or without its
+// encrypted extension. A relative name is looked for relative to the
+// current directory, then to RootDir.
+func (j *Journal) lookup(name string) (*FilePair, error) {
+	paths, err := j.namePaths(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, enc := range paths {
+		if !strings.HasSuffix(enc, j.encryptedFileExt) {
+			enc = j.encPath(enc)
+		}
+		for i := range j.Files {
+			if j.Files[i].enc == enc {
+				return &j.Files[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Error: %s is not an encrypted file in %s", name, j.RootDir)
+}
+
+// namePaths returns the paths name may refer to: relative to the current
+// directory and, for a relative name, to RootDir, so that a journal given by
+// JOURNAL_DIR can be used from anywhere.
+func (j *Journal) namePaths(name string) ([]string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %s is not a valid path: %s", name, err)
+	}
+	if filepath.IsAbs(name) {
+		return []string{abs}, nil
+	}
+
+	return []string{abs, filepath.Join(j.RootDir, name)}, nil
+}
+
+// resolvePath returns the path of name, which is relative to the current
+// directory if that is within the journal, and to RootDir otherwise.
+func (j *Journal) resolvePath(name string) (string, error) {
+	paths, err := j.namePaths(name)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(j.RootDir, paths[0]); err == nil && !strings.HasPrefix(rel, "..") {
+		return paths[0], nil
+	}
+
+	return paths[len(paths)-1], nil
+}
+
+// Grep searches the decrypted contents of every encrypted file for pattern,
+// writing matching lines to w as path:line:text. Plaintext is never written
+// to disk.
+func (j *Journal) Grep(pattern *regexp.Regexp, w io.Writer) error {
+	for _, file := range j.Files {
+		var content bytes.Buffer
+		ctx, cancel := j.context()
+		err := j.crypter.DecryptTo(ctx, file.encrypted(), &content)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Error decrypting file %s: %w", file.encrypted(), err)
+		}
+
+		scanner := bufio.NewScanner(&content)
+		for n := 1; scanner.Scan(); n++ {
+			if pattern.Match(scanner.Bytes()) {
+				fmt.Fprintf(w, "%s:%d:%s\n", j.relPath(file.plain), n, scanner.Text())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("Error reading decrypted file %s: %s", file.encrypted(), err)
+		}
+	}
+
+	return nil
+}
+
+// List writes each entry's name, encrypted size and whether it is unlocked
+// to w. long adds the modification time and footprint path.
+func (j *Journal) List(w io.Writer, long bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, file := range j.Files {
+		info, err := os.Stat(file.encrypted())
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %s", file.encrypted(), err)
+		}
+
+		state := j.fileState(file)
+
+		if !long {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", j.relPath(file.plain), info.Size(), state)
+			continue
+		}
+
+		footprint := "-"
+		if file.hidden {
+			footprint = j.relPath(file.footprint())
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", j.relPath(file.plain), info.Size(), state,
+			info.ModTime().Format("2006-01-02 15:04"), footprint)
+	}
+
+	return tw.Flush()
+}
+
+// Verify decrypts every encrypted file, discarding the plaintext, and writes
+// to w each file that fails followed by a summary. It returns an error if any
+// file failed.
+func (j *Journal) Verify(w io.Writer) error {
+	failed := 0
+	for _, file := range j.Files {
+		ctx, cancel := j.context()
+		err := j.crypter.DecryptTo(ctx, file.encrypted(), ioutil.Discard)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %s\n", j.relPath(file.encrypted()), err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Verify failed: %d of %d files could not be decrypted", failed, len(j.Files))
+	}
+
+	fmt.Fprintf(w, "Verified journal: %d files decrypted successfully\n", len(j.Files))
+	return nil
+}
+
+// fileState reports whether file is locked or unlocked. Files kept in place by
+// unlock --keep have no footprint, only plaintext alongside them.
+func (j *Journal) fileState(file FilePair) string {
+	if file.hidden {
+		return "unlocked"
+	}
+	if file.PlainExists() && j.unlocked() {
+		return "unlocked"
+	}
+	return "locked"
+}
+
+// ListEntries describes each encrypted file of the journal as locked, or
+// unlocked if its plaintext is out for editing.
+func (j *Journal) ListEntries() ([]Entry, error) {
+	entries := []Entry{}
+	for _, file := range j.Files {
+		info, err := os.Stat(file.encrypted())
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s: %s", file.encrypted(), err)
+		}
+
+		state := j.fileState(file)
+
+		mtime := info.ModTime()
+		entries = append(entries, Entry{
+			Path:     j.relPath(file.encrypted()),
+			Name:     j.relPath(file.plain),
+			State:    state,
+			Modified: &mtime,
+		})
+	}
+
+	return entries, nil
+}
+
+// New creates the entry name, or one named for today in the journal's date
+// layout if name is empty, prefilled from the journal's .template, and
+// opens it in the editor. The entry is encrypted once the editor exits, unless
+// the journal is unlocked, in which case the plaintext is left for lock to
+// encrypt.
+func (j *Journal) New(name string) error {
+	if name == "" {
+		name = time.Now().Format(j.dateLayout)
+	}
+
+	file := FilePair{plain: filepath.Join(j.RootDir, name)}
+	file.enc = j.encPath(file.plain)
+
+	for _, existing := range []string{file.enc, file.footprint(), file.plain} {
+		if _, err := os.Stat(existing); err == nil {
+			return fmt.Errorf("Entry %s already exists. Run journal edit %s to change it", name, name)
+		}
+	}
+
+	template, err := ioutil.ReadFile(filepath.Join(j.RootDir, ".template"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error reading template: %s", err)
+	}
+	content := []byte(strings.Replace(string(template), "{{date}}", time.Now().Format(j.dateLayout), -1))
+
+	if err := os.MkdirAll(filepath.Dir(file.plain), 0700); err != nil {
+		return fmt.Errorf("Error creating entry directory: %s", err)
+	}
+
+	if j.unlocked() {
+		if err := ioutil.WriteFile(file.plain, content, 0600); err != nil {
+			return fmt.Errorf("Error creating entry %s: %s", file.plain, err)
+		}
+		return j.runEditor(file.plain)
+	}
+
+	tmp, err := ioutil.TempFile(j.tempDir, "journal-*"+filepath.Ext(file.plain))
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %s", err)
+	}
+	defer shred(tmp.Name())
+
+	_, err = tmp.Write(content)
+	tmp.Close()
+	if err != nil {
+		return fmt.Errorf("Error writing temporary file: %s", err)
+	}
+
+	if err := j.runEditor(tmp.Name()); err != nil {
+		return fmt.Errorf("Editor exited with an error, %s not created: %s", name, err)
+	}
+
+	after, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, after) {
+		fmt.Printf("Entry %s was not changed, not saving\n", name)
+		return nil
+	}
+
+	scratch := FilePair{enc: file.enc, plain: tmp.Name()}
+	if err := scratch.encryptStaged(j); err != nil {
+		return fmt.Errorf("Error encrypting file %s: %s", file.enc, err)
+	}
+
+	return j.updateEncChecklist(func(checklist *Checklist) error {
+		return j.hashEncrypted(checklist, []FilePair{scratch})
+	})
+}
+
+// Add imports the plaintext file at path as the entry name, encrypting it to
+// the journal's recipients, and removes path unless keepPlain is set. The
+// plaintext is copied in for lock to encrypt if the journal is unlocked.
+// An existing entry is never overwritten.
+func (j *Journal) Add(path, name string, keepPlain bool) error {
+	src, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("Error: %s is not a valid path: %s", path, err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %s", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("Error: %s is not a regular file", src)
+	}
+
+	file := FilePair{plain: filepath.Join(j.RootDir, name)}
+	file.enc = j.encPath(file.plain)
+	if rel, err := filepath.Rel(j.RootDir, file.plain); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("Error: %s is outside the journal %s", name, j.RootDir)
+	}
+	if strings.HasSuffix(file.plain, j.encryptedFileExt) {
+		return fmt.Errorf("Error: %s is already encrypted", src)
+	}
+
+	// a plaintext file already inside the journal is added where it is
+	inPlace := src == file.plain
+	for _, existing := range []string{file.enc, file.footprint(), file.plain} {
+		if _, err := os.Lstat(existing); err == nil && !(inPlace && existing == file.plain) {
+			return fmt.Errorf("Entry %s already exists", name)
+		}
+	}
+
+	if !j.dryRun {
+		if err := os.MkdirAll(filepath.Dir(file.plain), 0700); err != nil {
+			return fmt.Errorf("Error creating entry directory: %s", err)
+		}
+	}
+
+	if j.unlocked() {
+		if !inPlace {
+			if err := j.copyPlain(src, file.plain); err != nil {
+				return err
+			}
+		}
+	} else {
+		scratch := FilePair{enc: file.enc, plain: src}
+		if err := scratch.encryptStaged(j); err != nil {
+			return fmt.Errorf("Error encrypting file %s: %s", file.enc, err)
+		}
+		if err := j.copyMetadata(src, file.enc); err != nil {
+			return fmt.Errorf("Error restoring metadata of %s: %s", file.enc, err)
+		}
+		err := j.updateEncChecklist(func(checklist *Checklist) error {
+			return j.hashEncrypted(checklist, []FilePair{file})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !keepPlain && !(inPlace && j.unlocked()) {
+		if err := j.removePlain(src); err != nil {
+			return fmt.Errorf("Error removing %s: %s", src, err)
+		}
+	}
+
+	if j.dryRun {
+		return nil
+	}
+	if j.unlocked() {
+		fmt.Printf("Added %s as %s, to be encrypted on lock\n", src, j.relPath(file.plain))
+	} else {
+		fmt.Printf("Added %s as %s\n", src, j.relPath(file.enc))
+	}
+	return nil
+}
+
+// copyPlain copies the plaintext file at from to to, along with its
+// permissions and modification time.
+func (j *Journal) copyPlain(from, to string) error {
+	if j.dryRun {
+		fmt.Printf("Would copy %s to %s\n", from, to)
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(from)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %s", from, err)
+	}
+	if err := ioutil.WriteFile(to, content, 0600); err != nil {
+		return fmt.Errorf("Error writing %s: %s", to, err)
+	}
+
+	return j.copyMetadata(from, to)
+}
+
+// runEditor opens path in the editor chosen by resolveEditor.
+func (j *Journal) runEditor(path string) error {
+	editor, err := resolveEditor(j.editor)
+	if err != nil {
+		return err
+	}
+	editorArgs := append(editor, path)
+
+	cmd := exec.Command(editorArgs[0], editorArgs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// resolveEditor returns the command line of the editor to run: $VISUAL, then
+// $EDITOR, then configured, then vi, or notepad on Windows. The first of
+// these that is set must be found on the PATH.
+func resolveEditor(configured string) ([]string, error) {
+	fallback := "vi"
+	if runtime.GOOS == "windows" {
+		fallback = "notepad"
+	}
+
+	for _, editor := range []string{os.Getenv("VISUAL"), os.Getenv("EDITOR"), configured, fallback} {
+		fields := strings.Fields(editor)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			return nil, fmt.Errorf("cannot run editor %s: %s", fields[0], err)
+		}
+		return fields, nil
+	}
+
+	return nil, fmt.Errorf("no editor found")
+}
+
+// unlocked reports whether the journal has been unlocked, i.e. has a .check
+// file.
+func (j *Journal) unlocked() bool {
+	_, err := os.Stat(filepath.Join(j.RootDir, ".check"))
+	return err == nil
+}
+
+// context returns the context for a single gpg or age invocation, bounded by
+// the journal's timeout if it has one.
+func (j *Journal) context() (context.Context, context.CancelFunc) {
+	if j.timeout > 0 {
+		return context.WithTimeout(context.Background(), j.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// rename moves the file at from to to.
+func (j *Journal) rename(from, to string) error {
+	if j.dryRun {
+		fmt.Printf("Would move %s to %s\n", from, to)
+		return nil
+	}
+
+	return os.Rename(from, to)
+}
+
+// removePlain deletes a plaintext file, shredding it first if the journal
+// was asked to.
+func (j *Journal) removePlain(path string) error {
+	if j.dryRun {
+		fmt.Printf("Would remove %s\n", path)
+		return nil
+	}
+
+	if j.shred {
+		if err := shred(path); err != nil {
+			return fmt.Errorf("Error shredding %s: %s", path, err)
+		}
+		return nil
+	}
+
+	return os.Remove(path)
+}
+
+// shred overwrites the file at path with zeros before removing it.
+func shred(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err := io.CopyN(f, zeros{}, info.Size()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	return os.Remove(path)
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// copyMetadata applies the permissions and modification time of the file at
+// from to the file at to.
+func (j *Journal) copyMetadata(from, to string) error {
+	if j.dryRun {
+		return nil
+	}
+
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(to, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(to, time.Now(), info.ModTime())
+}
+
+// ignored reports whether path matches one of the journal's ignore
+// patterns, either by its base name or its path relative to RootDir.
+func (j *Journal) ignored(path string) bool {
+	rel := filepath.ToSlash(j.relPath(path))
+	for _, pattern := range j.ignore {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// excludeExts returns exts, each given a leading dot if it has none.
+func excludeExts(exts []string) []string {
+	var out []string
+	for _, ext := range exts {
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext != "" {
+			out = append(out, ext)
+		}
+	}
+
+	return out
+}
+
+// excluded reports whether the name of path, less the encrypted file
+// extension, ends with one of the journal's excluded extensions.
+func (j *Journal) excluded(path string) bool {
+	stem := strings.TrimSuffix(filepath.Base(path), j.encryptedFileExt)
+	for _, ext := range j.excludeExt {
+		if strings.HasSuffix(stem, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// entryFilter returns a filter accepting the plaintext files belonging to
+// the journal. Encrypted files left in place by unlock --keep are not
+// plaintext.
+func (j *Journal) entryFilter() func(path string, info os.FileInfo) bool {
+	entries := j.entryPaths()
+	return func(path string, info os.FileInfo) bool {
+		if info.IsDir() {
+			if j.topLevel && path != j.RootDir {
+				return false
+			}
+			return nonHiddenFilesFilter(path, info) && !j.ignored(path)
+		}
+		// plaintext is never a symlink, and one would be shredded through
+		if info.Mode()&os.ModeSymlink != 0 {
+			return false
+		}
+		if strings.HasSuffix(path, j.encryptedFileExt) {
+			return false
+		}
+
+		// hidden entries are told apart from other dotfiles by their
+		// encrypted file
+		if entries[path] {
+			return true
+		}
+
+		return nonHiddenFilesFilter(path, info) && !j.ignored(path) && !j.excluded(path)
+	}
+}
+
+// pathSet returns the set of paths.
+func pathSet(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		set[path] = true
+	}
+
+	return set
+}
+
+// Diff returns the paths, relative to RootDir, of files modified since the
+// journal was unlocked.
+func (j *Journal) Diff() ([]string, error) {
+	if !j.unlocked() {
+		return nil, fmt.Errorf("Journal %s is not unlocked (no .check file). Run journal unlock first", j.RootDir)
+	}
+
+	checklist, err := j.readChecklist()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, _, err := checklist.Diff()
+	if err != nil {
+		return nil, fmt.Errorf("Could not calculate file changes: %s", err)
+	}
+
+	for i := range changes {
+		changes[i] = j.relPath(changes[i])
+	}
+
+	return changes, nil
+}
+
+// relPath returns path relative to RootDir where possible.
+func (j *Journal) relPath(path string) string {
+	rel, err := filepath.Rel(j.RootDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// entryDate returns the date at the start of the name of file, or of its path
+// within the journal, laid out as the journal's date layout. Files not named
+// by date are dated by the modification time of their encrypted file.
+func (j *Journal) entryDate(file FilePair) (time.Time, error) {
+	width := len(time.Time{}.Format(j.dateLayout))
+	rel := filepath.ToSlash(j.relPath(file.plain))
+	for _, name := range []string{filepath.Base(rel), rel} {
+		if len(name) < width {
+			continue
+		}
+		if date, err := time.ParseInLocation(j.dateLayout, name[:width], time.Local); err == nil {
+			return date, nil
+		}
+	}
+
+	info, err := os.Stat(file.encrypted())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// entryPaths returns the set of the plaintext paths of the journal's
+// encrypted files.
+func (j *Journal) entryPaths() map[string]bool {
+	paths := make([]string, len(j.Files))
+	for i, file := range j.Files {
+		paths[i] = file.plain
+	}
+
+	return pathSet(paths)
+}
+
+// writeChecklist replaces the journal's .check with checklist.
+func (j *Journal) writeChecklist(checklist *Checklist) error {
+	checkfile, err := os.Create(filepath.Join(j.RootDir, ".check"))
+	if err != nil {
+		return fmt.Errorf("Error creating checklist file: %s", err)
+	}
+
+	checkWriter := bufio.NewWriter(checkfile)
+	if err := checklist.Write(checkWriter); err != nil {
+		return fmt.Errorf("Error writing checklist file: %s", err)
+	}
+	checkWriter.Flush()
+	checkfile.Close()
+
+	return nil
+}
+
+// readChecklist loads the checklist recorded in .check by Unlock.
+func (j *Journal) readChecklist() (*Checklist, error) {
+	checkfile, err := os.Open(filepath.Join(j.RootDir, ".check"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not find open checklist file: %s", err)
+	}
+	defer checkfile.Close()
+
+	checklist, err := ChecklistFromReader(bufio.NewReader(checkfile), j.RootDir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read from checklist file: %s", err)
+	}
+
+	return checklist, nil
+}
+
+// discover walks RootDir for the journal's encrypted files, or their
+// footprints while it is unlocked. Files are sorted by plaintext path, so
+// that they are processed in the same order whether or not the journal is
+// unlocked.
+func (j *Journal) discover() ([]FilePair, error) {
+	var checklist *Checklist
+	if j.unlocked() {
+		var err error
+		if checklist, err = j.readChecklist(); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []FilePair
+	err := filepath.Walk(j.RootDir, func(path string, info os.FileInfo, err error) error {
+		return j.walkFile(&files, checklist, path, info, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].plain < files[b].plain
+	})
+
+	// a followed symlink and the file it points to are the same entry
+	if j.followSymlinks {
+		unique := files[:0]
+		for i, file := range files {
+			if i == 0 || file.plain != files[i-1].plain {
+				unique = append(unique, file)
+			}
+		}
+		files = unique
+	}
+
+	return files, nil
+}
+
+func (j *Journal) walkFile(files *[]FilePair, checklist *Checklist, path string, info os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	// hidden directories such as .git are not part of the journal, nor are
+	// any subdirectories of a top-level journal
+	if info.IsDir() {
+		if path != j.RootDir && (j.topLevel || strings.HasPrefix(info.Name(), ".") || j.ignored(path)) {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if !strings.HasSuffix(path, j.encryptedFileExt) {
+		return nil
+	}
+
+	// renaming a symlink to its footprint or locking over it would leave the
+	// file it points to behind, so a followed symlink is taken as that file
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !j.followSymlinks {
+			return nil
+		}
+		// a link to an unlocked entry dangles until it is locked again
+		target, err := j.resolveSymlink(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Warning: skipping symlink %s: %s\n", j.relPath(path), err)
+			return nil
+		}
+		path = target
+	}
+
+	file := FilePair{
+		enc:   path,
+		plain: j.plainPath(path),
+	}
+
+	// an unlocked journal holds footprints (.foo.gpg) in place of the
+	// encrypted files, which pair with the same plaintext as foo.gpg. A
+	// hidden file is only a footprint while that plaintext is out, so an
+	// entry that is itself hidden (.foo.gpg, footprint ..foo.gpg) is not
+	// mistaken for one.
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		raw := filepath.Join(
+			filepath.Dir(path),
+			strings.TrimPrefix(filepath.Base(path), "."),
+		)
+		plain := j.plainPath(raw)
+
+		_, err := os.Stat(plain)
+		if err == nil || (checklist != nil && checklist.Contains(plain)) {
+			file = FilePair{enc: raw, plain: plain, hidden: true}
+		}
+	}
+
+	if j.ignored(file.enc) || j.ignored(file.plain) || j.excluded(file.enc) {
+		return nil
+	}
+
+	*files = append(*files, file)
+	return nil
+}
+
+// plainPath returns the path the encrypted file at enc unlocks to, which
+// gains the plaintext extension if it would otherwise have none.
+func (j *Journal) plainPath(enc string) string {
+	plain := strings.TrimSuffix(enc, j.encryptedFileExt)
+	if j.plainExt != "" && filepath.Ext(plain) == "" {
+		plain += j.plainExt
+	}
+	return plain
+}
+
+// encPath returns the path the plaintext at plain encrypts to, the reverse
+// of plainPath.
+func (j *Journal) encPath(plain string) string {
+	if j.plainExt != "" && strings.HasSuffix(plain, j.plainExt) {
+		if stripped := strings.TrimSuffix(plain, j.plainExt); filepath.Ext(stripped) == "" {
+			return stripped + j.encryptedFileExt
+		}
+	}
+	return plain + j.encryptedFileExt
+}
+
+// resolveSymlink returns the path within the journal of the encrypted file
+// the symlink at path points to.
+func (j *Journal) resolveSymlink(path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(j.RootDir)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the journal", target)
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("%s is hidden", target)
+		}
+	}
+	if !strings.HasSuffix(rel, j.encryptedFileExt) {
+		return "", fmt.Errorf("%s is not an encrypted file", target)
+	}
+	if j.topLevel && strings.Contains(rel, string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is in a subdirectory", target)
+	}
+
+	return filepath.Join(j.RootDir, rel), nil
+}
@@ -0,0 +1,2555 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// testJournal is a journal in a temporary directory whose entries the fake
+// gpg encrypts to me@example.com.
+type testJournal struct {
+	dir  string
+	fake *fakeGPG
+}
+
+// newTestJournal creates a test journal holding entries, mapping paths
+// relative to its root to their plaintext, and returns a function removing
+// it.
+func newTestJournal(t testing.TB, entries map[string]string) (*testJournal, func()) {
+	t.Helper()
+
+	dir, removeDir := tempJournal(t, map[string]string{".gpgid": "me@example.com\n"})
+	fake, removeFake := newFakeGPG(t)
+	tj := &testJournal{dir: dir, fake: fake}
+	for name, content := range entries {
+		fake.encrypt(t, tj.path(name+DefaultFileExt), content, defaultFakeKeys[0].keyID())
+	}
+
+	return tj, func() {
+		removeFake()
+		removeDir()
+	}
+}
+
+// open opens the journal with opts, running the fake gpg.
+func (tj *testJournal) open(t testing.TB, opts Options) *Journal {
+	t.Helper()
+
+	if opts.GPGCommand == "" {
+		opts.GPGCommand = tj.fake.command()
+	}
+	j, err := Open(tj.dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// path returns the absolute path of name, relative to the journal root.
+func (tj *testJournal) path(name string) string {
+	return filepath.Join(tj.dir, filepath.FromSlash(name))
+}
+
+func (tj *testJournal) read(t testing.TB, name string) string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(tj.path(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func (tj *testJournal) write(t testing.TB, name, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(tj.path(name)), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tj.path(name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (tj *testJournal) remove(t testing.TB, name string) {
+	t.Helper()
+
+	if err := os.Remove(tj.path(name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decrypt returns the plaintext of the encrypted file name.
+func (tj *testJournal) decrypt(t testing.TB, name string) string {
+	t.Helper()
+
+	plain, _ := tj.fake.decrypt(t, tj.path(name))
+	return plain
+}
+
+// files returns the paths of the files in the journal, relative to its root,
+// leaving out its .gpgid and .enc-check.
+func (tj *testJournal) files(t testing.TB) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.Walk(tj.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(tj.dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != ".gpgid" && rel != EncChecklistFile {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// expectFiles fails t unless the journal holds exactly want, as listed by
+// files.
+func (tj *testJournal) expectFiles(t testing.TB, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	if got := tj.files(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("journal holds %q, want %q", got, want)
+	}
+}
+
+func TestUnlockLock(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{
+		"2020-01-01.txt":     "first",
+		"2020-01-02.txt":     "second",
+		"2020-01-03.txt":     "third",
+		"trips/my trip.md":   "trip",
+		".hidden/secret.txt": "never touched",
+	})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t,
+		".check",
+		".hidden/secret.txt.gpg",
+		"2020-01-01.txt", ".2020-01-01.txt.gpg",
+		"2020-01-02.txt", ".2020-01-02.txt.gpg",
+		"2020-01-03.txt", ".2020-01-03.txt.gpg",
+		"trips/my trip.md", "trips/.my trip.md.gpg",
+	)
+	if got := tj.read(t, "trips/my trip.md"); got != "trip" {
+		t.Errorf("unlocked %q, want %q", got, "trip")
+	}
+
+	tj.write(t, "2020-01-02.txt", "second, edited")
+	tj.remove(t, "2020-01-03.txt")
+	tj.write(t, "trips/2020-02-01.txt", "new")
+
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t,
+		".hidden/secret.txt.gpg",
+		"2020-01-01.txt.gpg",
+		"2020-01-02.txt.gpg",
+		"trips/my trip.md.gpg",
+		"trips/2020-02-01.txt.gpg",
+	)
+	for name, want := range map[string]string{
+		"2020-01-01.txt.gpg":       "first",
+		"2020-01-02.txt.gpg":       "second, edited",
+		"trips/my trip.md.gpg":     "trip",
+		"trips/2020-02-01.txt.gpg": "new",
+	} {
+		if got := tj.decrypt(t, name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+
+	// an unchanged file keeps its encrypted file as it was
+	encrypted := 0
+	for _, args := range tj.fake.runs(t) {
+		if hasArg(args, "-e") {
+			encrypted++
+		}
+	}
+	if encrypted != 2 {
+		t.Errorf("encrypted %d files, want the edited and the new file only", encrypted)
+	}
+}
+
+// reverseCrypter is a Crypter that "encrypts" a file by reversing it.
+type reverseCrypter struct {
+	checked bool
+}
+
+func reverse(content []byte) []byte {
+	reversed := make([]byte, len(content))
+	for i, b := range content {
+		reversed[len(content)-1-i] = b
+	}
+	return reversed
+}
+
+func (c *reverseCrypter) Encrypt(ctx context.Context, in, out string) error {
+	content, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, reverse(content), 0600)
+}
+
+func (c *reverseCrypter) Decrypt(ctx context.Context, in, out string) error {
+	return c.Encrypt(ctx, in, out)
+}
+
+func (c *reverseCrypter) DecryptTo(ctx context.Context, in string, w io.Writer) error {
+	content, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(reverse(content))
+	return err
+}
+
+func (c *reverseCrypter) CheckRecipients(ctx context.Context) error {
+	c.checked = true
+	return nil
+}
+
+func TestJournalUsesCrypter(t *testing.T) {
+	tj, cleanup := newTestJournal(t, nil)
+	defer cleanup()
+	tj.write(t, "a.txt.gpg", "olleh")
+
+	c := &reverseCrypter{}
+	j := tj.open(t, Options{})
+	j.crypter = c
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "a.txt"); got != "hello" {
+		t.Fatalf("unlocked %q, want %q", got, "hello")
+	}
+
+	var buf bytes.Buffer
+	j = tj.open(t, Options{})
+	j.crypter = c
+	if err := j.Cat("a.txt", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello" {
+		t.Errorf("cat wrote %q, want %q", buf.String(), "hello")
+	}
+
+	tj.write(t, "a.txt", "hello, world")
+	j = tj.open(t, Options{})
+	j.crypter = c
+	if err := j.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.checked {
+		t.Error("lock did not check the recipients before encrypting")
+	}
+	if got := tj.read(t, "a.txt.gpg"); got != "dlrow ,olleh" {
+		t.Errorf("locked %q, want %q", got, "dlrow ,olleh")
+	}
+	if runs := tj.fake.runs(t); len(runs) != 0 {
+		t.Errorf("ran gpg %q, want the journal's crypter only", runs)
+	}
+}
+
+func TestUnlockExt(t *testing.T) {
+	tj, cleanup := newTestJournal(t, nil)
+	defer cleanup()
+	me := defaultFakeKeys[0].keyID()
+	tj.fake.encrypt(t, tj.path("armored.txt.asc"), "armored", me)
+	tj.fake.encrypt(t, tj.path("binary.txt.gpg"), "binary", me)
+
+	j := tj.open(t, Options{Ext: ".asc"})
+	if len(j.Files) != 1 || j.Files[0].plain != tj.path("armored.txt") {
+		t.Fatalf("discovered %v, want armored.txt.asc only", j.Files)
+	}
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", "armored.txt", ".armored.txt.asc", "binary.txt.gpg")
+}
+
+func TestNonHiddenFilesFilter(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/journal/a.txt", true},
+		{"/journal/sub/b.txt", true},
+		{"/journal/.check", false},
+		{"/journal/.gpgid", false},
+		{"/journal/.a.txt.gpg", false},
+		{"/journal/sub/.DS_Store", false},
+	}
+	for _, tt := range tests {
+		if got := nonHiddenFilesFilter(filepath.FromSlash(tt.path), nil); got != tt.want {
+			t.Errorf("nonHiddenFilesFilter(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUnlockChecklistHoldsVisibleEntries(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	defer cleanup()
+	tj.write(t, ".DS_Store", "finder")
+	tj.write(t, "sub/.notes", "notes")
+
+	j := tj.open(t, Options{})
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	checklist, err := j.readChecklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, file := range checklist.files {
+		got = append(got, j.relPath(file.path))
+	}
+	if want := []string{"a.txt", filepath.Join("sub", "b.txt")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("checklist holds %q, want %q", got, want)
+	}
+}
+
+func TestDiscoverPairsFootprints(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"entry": "visible", ".profile": "hidden entry"})
+	defer cleanup()
+
+	locked := []FilePair{
+		{enc: tj.path(".profile.gpg"), plain: tj.path(".profile")},
+		{enc: tj.path("entry.gpg"), plain: tj.path("entry")},
+	}
+	if got := tj.open(t, Options{}).Files; !reflect.DeepEqual(got, locked) {
+		t.Errorf("discovered %v in the locked journal, want %v", got, locked)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", ".profile", "..profile.gpg", "entry", ".entry.gpg")
+
+	unlocked := []FilePair{
+		{enc: tj.path(".profile.gpg"), plain: tj.path(".profile"), hidden: true},
+		{enc: tj.path("entry.gpg"), plain: tj.path("entry"), hidden: true},
+	}
+	if got := tj.open(t, Options{}).Files; !reflect.DeepEqual(got, unlocked) {
+		t.Errorf("discovered %v in the unlocked journal, want %v", got, unlocked)
+	}
+}
+
+func TestLockEncryptsNewFiles(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "note.txt", "new note")
+	tj.write(t, "2021/01/deep.txt", "new in a new directory")
+
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "note.txt.gpg", "2021/01/deep.txt.gpg")
+	if got := tj.decrypt(t, "note.txt.gpg"); got != "new note" {
+		t.Errorf("note.txt.gpg holds %q, want %q", got, "new note")
+	}
+	if got := tj.decrypt(t, "2021/01/deep.txt.gpg"); got != "new in a new directory" {
+		t.Errorf("2021/01/deep.txt.gpg holds %q, want %q", got, "new in a new directory")
+	}
+}
+
+func TestLockShredsPlaintext(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "secret"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a longer secret")
+
+	// a second link to the plaintext shows what was left in its blocks
+	witness := filepath.Join(tj.fake.dir, "witness")
+	if err := os.Link(tj.path("a.txt"), witness); err != nil {
+		t.Skipf("cannot link the plaintext: %s", err)
+	}
+
+	if err := tj.open(t, Options{Shred: true}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg")
+
+	content, err := ioutil.ReadFile(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := make([]byte, len("a longer secret")); !bytes.Equal(content, want) {
+		t.Errorf("the removed plaintext held %q, want it overwritten with zeros", content)
+	}
+}
+
+func TestUnlockLockPreservesMetadata(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"old.txt": "old", "edited.txt": "edited"})
+	defer cleanup()
+
+	written := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"old.txt.gpg", "edited.txt.gpg"} {
+		if err := os.Chmod(tj.path(name), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(tj.path(name), written, written); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"old.txt", "edited.txt"} {
+		info, err := os.Stat(tj.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 || !info.ModTime().Equal(written) {
+			t.Errorf("unlocked %s with mode %o and mtime %s, want %o and %s", name, info.Mode().Perm(), info.ModTime(), 0640, written)
+		}
+	}
+
+	edited := time.Date(2019, 3, 2, 12, 0, 0, 0, time.UTC)
+	tj.write(t, "edited.txt", "edited again")
+	if err := os.Chtimes(tj.path("edited.txt"), edited, edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]time.Time{"old.txt.gpg": written, "edited.txt.gpg": edited} {
+		info, err := os.Stat(tj.path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 || !info.ModTime().Equal(want) {
+			t.Errorf("locked %s with mode %o and mtime %s, want %o and %s", name, info.Mode().Perm(), info.ModTime(), 0640, want)
+		}
+	}
+}
+
+func TestLockLocksModeChange(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(tj.path("a.txt"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(tj.path("a.txt.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0400 {
+		t.Errorf("locked a.txt.gpg with mode %o, want 400", info.Mode().Perm())
+	}
+}
+
+func TestDiscoverNested(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{
+		"2023/01/01.txt": "a",
+		"2023/01/02.txt": "b",
+		"2023/02/01.txt": "c",
+		"2024/01.txt":    "d",
+		".git/x/y.txt":   "not an entry",
+	})
+	defer cleanup()
+
+	got := tj.open(t, Options{}).Files
+	var want []FilePair
+	for _, name := range []string{"2023/01/01.txt", "2023/01/02.txt", "2023/02/01.txt", "2024/01.txt"} {
+		want = append(want, FilePair{enc: tj.path(name + ".gpg"), plain: tj.path(name)})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("discovered %v, want %v", got, want)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", ".git/x/y.txt.gpg",
+		"2023/01/01.txt", "2023/01/.01.txt.gpg",
+		"2023/01/02.txt", "2023/01/.02.txt.gpg",
+		"2023/02/01.txt", "2023/02/.01.txt.gpg",
+		"2024/01.txt", "2024/.01.txt.gpg",
+	)
+	for i, file := range tj.open(t, Options{}).Files {
+		want[i].hidden = true
+		if file != want[i] {
+			t.Errorf("discovered %v in the unlocked journal, want %v", file, want[i])
+		}
+	}
+}
+
+// snapshot returns the contents of every file in the journal by path.
+func (tj *testJournal) snapshot(t testing.TB) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	for _, name := range tj.files(t) {
+		files[name] = tj.read(t, name)
+	}
+	return files
+}
+
+func TestDryRun(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	defer cleanup()
+
+	before := tj.snapshot(t)
+	if err := tj.open(t, Options{DryRun: true}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run of unlock changed the journal from %q to %q", before, after)
+	}
+	if runs := tj.fake.runs(t); len(runs) != 0 {
+		t.Errorf("dry run of unlock ran gpg %q", runs)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "edited")
+	tj.remove(t, "b.txt")
+	tj.write(t, "d.txt", "new")
+	runs := len(tj.fake.runs(t))
+
+	before = tj.snapshot(t)
+	if err := tj.open(t, Options{DryRun: true}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("dry run of lock changed the journal from %q to %q", before, after)
+	}
+	for _, args := range tj.fake.runs(t)[runs:] {
+		if hasArg(args, "-e") {
+			t.Errorf("dry run of lock encrypted with %q", args)
+		}
+	}
+}
+
+// failingCrypter is the Crypter it embeds, failing to encrypt the plaintext
+// at fail.
+type failingCrypter struct {
+	Crypter
+	fail string
+}
+
+func (c *failingCrypter) Encrypt(ctx context.Context, in, out string) error {
+	if in == c.fail {
+		return errors.New("encryption failed")
+	}
+	return c.Crypter.Encrypt(ctx, in, out)
+}
+
+// truncatingCrypter fails every encryption part way through, leaving its
+// output truncated as an interrupted gpg would.
+type truncatingCrypter struct {
+	Crypter
+}
+
+func (c truncatingCrypter) Encrypt(ctx context.Context, in, out string) error {
+	if err := ioutil.WriteFile(out, []byte("-----BEGIN"), 0600); err != nil {
+		return err
+	}
+	return errors.New("encryption failed")
+}
+
+func TestLockRollsBack(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "d.txt": "d"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		tj.write(t, name, name+" edited")
+	}
+	tj.remove(t, "d.txt")
+	tj.write(t, "e.txt", "new")
+
+	before := tj.snapshot(t)
+	j := tj.open(t, Options{})
+	j.crypter = &failingCrypter{Crypter: j.crypter, fail: tj.path("b.txt")}
+	err := j.Lock()
+	if err == nil || !strings.Contains(err.Error(), "journal left unlocked") {
+		t.Fatalf("got error %v, want the journal left unlocked", err)
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("failed lock changed the journal from %q to %q", before, after)
+	}
+
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg", "c.txt.gpg", "e.txt.gpg")
+	if got := tj.decrypt(t, "b.txt.gpg"); got != "b.txt edited" {
+		t.Errorf("b.txt.gpg holds %q, want %q", got, "b.txt edited")
+	}
+}
+
+func TestLockChecksRecipientsFirst(t *testing.T) {
+	tests := []struct {
+		name  string
+		gpgid string // path of the .gpgid naming the unknown key
+	}{
+		{name: "root", gpgid: ".gpgid"},
+		{name: "subdirectory", gpgid: "sub/.gpgid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+			defer cleanup()
+
+			if err := tj.open(t, Options{}).Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+				tj.write(t, name, name+" edited")
+			}
+			tj.write(t, "d.txt", "new")
+			tj.write(t, tt.gpgid, "nobody@example.com\n")
+
+			before := tj.snapshot(t)
+			os.Remove(tj.fake.log)
+			err := tj.open(t, Options{}).Lock()
+			if err == nil || !strings.Contains(err.Error(), "nobody@example.com") {
+				t.Fatalf("got error %v, want one naming the unknown key", err)
+			}
+			if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+				t.Errorf("failed lock changed the journal from %q to %q", before, after)
+			}
+			for _, run := range tj.fake.runs(t) {
+				if hasArg(run, "-e") {
+					t.Errorf("ran gpg %q, want nothing encrypted", run)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlockRejectsSilentFailure(t *testing.T) {
+	for _, batchSize := range []int{0, 2} {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+		defer cleanup()
+		os.Setenv(fakeGPGEmptyEnv, "1")
+
+		err := tj.open(t, Options{BatchSize: batchSize, Jobs: 1}).Unlock()
+		if !errors.Is(err, ErrDecryptionFailed) || !strings.Contains(err.Error(), tj.path("a.txt.gpg")) {
+			t.Errorf("batch size %d: got error %v, want a.txt.gpg's decryption to fail", batchSize, err)
+		}
+		tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg")
+	}
+}
+
+func TestUnlockLockEmptyEntry(t *testing.T) {
+	for _, batchSize := range []int{0, 2} {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+		defer cleanup()
+		if err := tj.open(t, Options{}).Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.write(t, "empty.txt", "")
+		if err := tj.open(t, Options{}).Lock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.expectFiles(t, "a.txt.gpg", "empty.txt.gpg")
+
+		if err := tj.open(t, Options{BatchSize: batchSize}).Unlock(); err != nil {
+			t.Fatalf("batch size %d: got error %v unlocking an empty entry", batchSize, err)
+		}
+		if got := tj.read(t, "empty.txt"); got != "" {
+			t.Errorf("batch size %d: empty.txt holds %q, want it empty", batchSize, got)
+		}
+		if err := tj.open(t, Options{}).Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tj.decrypt(t, "empty.txt.gpg"); got != "" {
+			t.Errorf("batch size %d: empty.txt.gpg holds %q, want it empty", batchSize, got)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       Options
+		ignorefile string
+	}{
+		{name: "flag", opts: Options{Ignore: []string{"*.png"}}},
+		{name: "ignore file", ignorefile: "# images\n*.png\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "old.png": "encrypted image"})
+			defer cleanup()
+			tj.write(t, "photo.png", "image")
+			tj.write(t, "sub/photo.png", "image")
+			if tt.ignorefile != "" {
+				tj.write(t, IgnoreFile, tt.ignorefile)
+			}
+
+			if err := tj.open(t, tt.opts).Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			tj.write(t, "b.txt", "b")
+			if err := tj.open(t, tt.opts).Lock(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"a.txt.gpg", "b.txt.gpg", "old.png.gpg", "photo.png", "sub/photo.png"}
+			if tt.ignorefile != "" {
+				want = append(want, IgnoreFile)
+			}
+			tj.expectFiles(t, want...)
+			for _, args := range tj.fake.runs(t) {
+				for _, arg := range args {
+					if strings.HasSuffix(arg, ".png") || strings.HasSuffix(arg, ".png.gpg") {
+						t.Errorf("ran gpg on an ignored file: %q", args)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLockNotUnlocked(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	err := tj.open(t, Options{}).Lock()
+	if err == nil || !strings.Contains(err.Error(), "is not unlocked (no .check file); nothing to lock") {
+		t.Errorf("got error %v, want the journal reported as not unlocked", err)
+	}
+	tj.expectFiles(t, "a.txt.gpg")
+}
+
+func TestList(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/b.txt": "bb", "sub/deeper/c.md": "ccc"})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	if err := tj.open(t, Options{}).List(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		names = append(names, strings.Fields(line)[0])
+	}
+	want := []string{"a.txt", filepath.Join("sub", "b.txt"), filepath.Join("sub", "deeper", "c.md")}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %q, want %q", names, want)
+	}
+
+	entries, err := tj.open(t, Options{}).ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if entry.Name != want[i] || entry.Path != want[i]+".gpg" || entry.State != "locked" {
+			t.Errorf("got entry %+v, want %s locked", entry, want[i])
+		}
+	}
+}
+
+func TestLockEncryptsToNearestGPGID(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+	tj.write(t, "shared/.gpgid", "two@example.com\nme@example.com\n")
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "private.txt", "private")
+	tj.write(t, "shared/notes/ours.txt", "shared")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	me, two := defaultFakeKeys[0].keyID(), defaultFakeKeys[1].keyID()
+	for name, want := range map[string][]string{
+		"private.txt.gpg":           {me},
+		"shared/notes/ours.txt.gpg": {two, me},
+	} {
+		if _, got := tj.fake.decrypt(t, tj.path(name)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s is encrypted to %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnlockTwice(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "unsaved edit")
+
+	err := tj.open(t, Options{}).Unlock()
+	if err == nil || !strings.Contains(err.Error(), "already unlocked") {
+		t.Errorf("got error %v, want the journal reported as already unlocked", err)
+	}
+	if got := tj.read(t, "a.txt"); got != "unsaved edit" {
+		t.Errorf("second unlock left %q, want the edit kept", got)
+	}
+
+	if err := tj.open(t, Options{Force: true}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "a.txt"); got != "a" {
+		t.Errorf("forced unlock left %q, want %q decrypted again", got, "a")
+	}
+	tj.expectFiles(t, ".check", "a.txt", ".a.txt.gpg")
+}
+
+func TestUnlockKeep(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{Keep: true}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", "a.txt", "a.txt.gpg", "b.txt", "b.txt.gpg", "c.txt", "c.txt.gpg")
+
+	tj.write(t, "a.txt", "a edited")
+	tj.remove(t, "b.txt")
+
+	var buf bytes.Buffer
+	if ok, err := tj.open(t, Options{}).Check(&buf); err != nil || !ok {
+		t.Errorf("check found problems with a deleted kept entry: %v %s", err, buf.String())
+	}
+
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "c.txt.gpg")
+	if got := tj.decrypt(t, "a.txt.gpg"); got != "a edited" {
+		t.Errorf("a.txt.gpg holds %q, want %q", got, "a edited")
+	}
+}
+
+func TestUnlockLockHiddenEntry(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"entry": "visible", ".profile": "hidden"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "entry", "visible edited")
+	tj.write(t, ".profile", "hidden edited")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	tj.expectFiles(t, ".profile.gpg", "entry.gpg")
+	for name, want := range map[string]string{"entry.gpg": "visible edited", ".profile.gpg": "hidden edited"} {
+		if got := tj.decrypt(t, name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUnlockRefusesFootprintOverEntry(t *testing.T) {
+	// the footprint of entry.gpg is the hidden entry .entry.gpg
+	tj, cleanup := newTestJournal(t, map[string]string{"entry": "visible", ".entry": "hidden"})
+	defer cleanup()
+	before := tj.snapshot(t)
+
+	for _, only := range [][]string{nil, {"entry"}} {
+		err := tj.open(t, Options{Only: only}).Unlock()
+		if err == nil || !strings.Contains(err.Error(), "its footprint .entry.gpg already exists") {
+			t.Errorf("only %q: got error %v, want the footprint named", only, err)
+		}
+		if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+			t.Fatalf("only %q: unlock changed the journal", only)
+		}
+	}
+
+	// the hidden entry has a footprint of its own, so unlocks alone
+	if err := tj.open(t, Options{Only: []string{".entry"}}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, ".entry", "hidden edited")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"entry.gpg": "visible", ".entry.gpg": "hidden edited"} {
+		if got := tj.decrypt(t, name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	t.Run("locked", func(t *testing.T) {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "taken.txt": "taken"})
+		defer cleanup()
+
+		if err := tj.open(t, Options{}).Move("a.txt", "2020/renamed.txt"); err != nil {
+			t.Fatal(err)
+		}
+		tj.expectFiles(t, "2020/renamed.txt.gpg", "taken.txt.gpg")
+
+		err := tj.open(t, Options{}).Move("2020/renamed.txt.gpg", "taken.txt")
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("got error %v moving onto an existing entry, want it refused", err)
+		}
+		tj.expectFiles(t, "2020/renamed.txt.gpg", "taken.txt.gpg")
+	})
+
+	t.Run("unlocked", func(t *testing.T) {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "taken.txt": "taken"})
+		defer cleanup()
+
+		if err := tj.open(t, Options{}).Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tj.open(t, Options{}).Move("a.txt", "renamed.txt"); err != nil {
+			t.Fatal(err)
+		}
+		tj.expectFiles(t, ".check", "renamed.txt", ".renamed.txt.gpg", "taken.txt", ".taken.txt.gpg")
+
+		if err := tj.open(t, Options{}).Move("renamed.txt", "taken.txt"); err == nil {
+			t.Error("moved an entry onto an existing one")
+		}
+
+		if err := tj.open(t, Options{}).Lock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.expectFiles(t, "renamed.txt.gpg", "taken.txt.gpg")
+		if got := tj.decrypt(t, "renamed.txt.gpg"); got != "a" {
+			t.Errorf("renamed.txt.gpg holds %q, want %q", got, "a")
+		}
+	})
+}
+
+func TestUnlockNoFootprint(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+
+	if err := tj.open(t, Options{NoFootprint: true}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", "a.txt", "b.txt")
+
+	tj.write(t, "b.txt", "b edited")
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg")
+	for name, want := range map[string]string{"a.txt.gpg": "a", "b.txt.gpg": "b edited"} {
+		if got := tj.decrypt(t, name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+
+	if err := tj.open(t, Options{Keep: true, NoFootprint: true}).Unlock(); err == nil {
+		t.Error("unlocked with both --keep and --no-footprint")
+	}
+}
+
+func TestFilesSortedByPath(t *testing.T) {
+	// the walk sees a.txt-2.gpg before a.txt.gpg, and every footprint
+	// before the visible files of an unlocked journal
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "1", "a.txt-2": "2", "b/c.txt": "3", "_d.txt": "4"})
+	defer cleanup()
+
+	want := []string{"_d.txt", "a.txt", "a.txt-2", filepath.Join("b", "c.txt")}
+	names := func(j *Journal) []string {
+		var names []string
+		for _, file := range j.Files {
+			names = append(names, j.relPath(file.plain))
+		}
+		return names
+	}
+	if got := names(tj.open(t, Options{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("locked journal files are %q, want %q", got, want)
+	}
+
+	if err := tj.open(t, Options{Jobs: 1}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	var decrypted []string
+	for _, args := range tj.fake.runs(t) {
+		if hasArg(args, "-d") {
+			rel, _ := filepath.Rel(tj.dir, args[len(args)-1])
+			decrypted = append(decrypted, strings.TrimSuffix(rel, ".gpg"))
+		}
+	}
+	if !reflect.DeepEqual(decrypted, want) {
+		t.Errorf("decrypted %q in turn, want %q", decrypted, want)
+	}
+	if got := names(tj.open(t, Options{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("unlocked journal files are %q, want %q", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, tj *testJournal)
+		want  string // a warning, or "" for none
+	}{
+		{
+			name:  "locked",
+			setup: func(t *testing.T, tj *testJournal) {},
+		},
+		{
+			name: "unlocked",
+			setup: func(t *testing.T, tj *testJournal) {
+				if err := tj.open(t, Options{}).Unlock(); err != nil {
+					t.Fatal(err)
+				}
+				tj.remove(t, "b.txt")
+			},
+		},
+		{
+			name: "plaintext left by a crash",
+			setup: func(t *testing.T, tj *testJournal) {
+				tj.write(t, "a.txt", "a")
+			},
+			want: "Warning: plaintext a.txt exists but the journal is locked",
+		},
+		{
+			name: "checklist lost",
+			setup: func(t *testing.T, tj *testJournal) {
+				if err := tj.open(t, Options{}).Unlock(); err != nil {
+					t.Fatal(err)
+				}
+				tj.remove(t, ".check")
+			},
+			want: "Warning: a.txt is unlocked but the journal has no .check file",
+		},
+		{
+			name: "entry and footprint lost",
+			setup: func(t *testing.T, tj *testJournal) {
+				if err := tj.open(t, Options{}).Unlock(); err != nil {
+					t.Fatal(err)
+				}
+				tj.remove(t, "b.txt")
+				tj.remove(t, ".b.txt.gpg")
+			},
+			want: "Warning: b.txt is recorded in .check but neither it nor its footprint exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+			defer cleanup()
+			tt.setup(t, tj)
+
+			var buf bytes.Buffer
+			ok, err := tj.open(t, Options{}).Check(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if !ok || buf.Len() > 0 {
+					t.Errorf("check warned %q, want no warning", buf.String())
+				}
+				return
+			}
+			if ok || !strings.Contains(buf.String(), tt.want) || !strings.Contains(buf.String(), "Run journal") {
+				t.Errorf("check warned %q, want %q and a remedy", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlockSince(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{
+		"2020-01-31.txt":  "old",
+		"2020-02-01.txt":  "recent",
+		"2020/03/05.md":   "dated by path in another layout",
+		"2020/03-06.md":   "dated by path",
+		"undated-old.txt": "old by its mtime",
+		"undated-new.txt": "recent by its mtime",
+	})
+	defer cleanup()
+
+	// the mtime of entries not dated by their name decides
+	old := time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local)
+	for _, file := range tj.open(t, Options{}).Files {
+		if err := os.Chtimes(file.enc, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(tj.path("undated-new.txt.gpg"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		layout string
+		want   []string
+	}{
+		{layout: "", want: []string{"2020-02-01.txt", "undated-new.txt"}},
+		{layout: "2006/01-02", want: []string{"2020/03-06.md", "undated-new.txt"}},
+	}
+	for _, tt := range tests {
+		j := tj.open(t, Options{Since: time.Date(2020, 2, 1, 0, 0, 0, 0, time.Local), DateLayout: tt.layout})
+		if err := j.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+
+		checklist, err := j.readChecklist()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, file := range checklist.files {
+			got = append(got, filepath.ToSlash(j.relPath(file.path)))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("layout %q: unlocked %q, want %q", tt.layout, got, tt.want)
+		}
+
+		if err := tj.open(t, Options{}).Lock(); err != nil {
+			t.Fatal(err)
+		}
+		tj.expectFiles(t, "2020-01-31.txt.gpg", "2020-02-01.txt.gpg", "2020/03-06.md.gpg", "2020/03/05.md.gpg", "undated-new.txt.gpg", "undated-old.txt.gpg")
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "c.txt": "c"})
+	defer cleanup()
+	// b.txt.gpg is only encrypted to someone else
+	tj.fake.encrypt(t, tj.path("b.txt.gpg"), "b", defaultFakeKeys[1].keyID())
+
+	err := tj.open(t, Options{KeepGoing: true, Jobs: 1}).Unlock()
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 files failed (b.txt.gpg)") {
+		t.Errorf("got error %v, want b.txt.gpg reported as failed", err)
+	}
+	if runs := len(tj.fake.runs(t)); runs != 3 {
+		t.Errorf("ran gpg %d times, want every file attempted", runs)
+	}
+	tj.expectFiles(t, ".check", "a.txt", ".a.txt.gpg", "b.txt.gpg", "c.txt", ".c.txt.gpg")
+
+	tj.write(t, "a.txt", "a edited")
+	tj.write(t, "c.txt", "c edited")
+	j := tj.open(t, Options{KeepGoing: true})
+	j.crypter = &failingCrypter{Crypter: j.crypter, fail: tj.path("c.txt")}
+	err = j.Lock()
+	if err == nil || !strings.Contains(err.Error(), "1 files failed to lock") {
+		t.Errorf("got error %v, want c.txt reported as failed", err)
+	}
+	tj.expectFiles(t, ".check", "a.txt.gpg", "b.txt.gpg", "c.txt", ".c.txt.gpg")
+
+	// the file that failed is still unlocked, and locks once it can
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg", "c.txt.gpg")
+	if got := tj.decrypt(t, "c.txt.gpg"); got != "c edited" {
+		t.Errorf("c.txt.gpg holds %q, want %q", got, "c edited")
+	}
+}
+
+func TestUnlockStopsAtFailure(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "c.txt": "c"})
+	defer cleanup()
+	tj.fake.encrypt(t, tj.path("b.txt.gpg"), "b", defaultFakeKeys[1].keyID())
+
+	err := tj.open(t, Options{Jobs: 1}).Unlock()
+	if !errors.Is(err, ErrNoSecretKey) {
+		t.Errorf("got error %v, want b.txt.gpg's missing secret key", err)
+	}
+
+	// what was unlocked before the failure can be locked again
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg", "c.txt.gpg")
+}
+
+func TestPlainExt(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"entry": "no extension", "notes.md": "markdown"})
+	defer cleanup()
+
+	opts := Options{PlainExt: "txt"}
+	if err := tj.open(t, opts).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".check", "entry.txt", ".entry.gpg", "notes.md", ".notes.md.gpg")
+
+	tj.write(t, "entry.txt", "edited")
+	tj.write(t, "new.txt", "new")
+	if err := tj.open(t, opts).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "entry.gpg", "new.gpg", "notes.md.gpg")
+	if got := tj.decrypt(t, "entry.gpg"); got != "edited" {
+		t.Errorf("entry.gpg holds %q, want %q", got, "edited")
+	}
+}
+
+func TestUnlockOnly(t *testing.T) {
+	entries := map[string]string{"2024-05-31.txt": "may", "2024-06-01.txt": "june", "2024-06-02.txt": "june", "trips/rome.txt": "rome"}
+	tests := []struct {
+		name string
+		only []string
+		want []string
+	}{
+		{name: "file", only: []string{"2024-06-01.txt.gpg"}, want: []string{"2024-06-01.txt"}},
+		{name: "plaintext name", only: []string{"2024-06-01.txt"}, want: []string{"2024-06-01.txt"}},
+		{name: "glob", only: []string{"2024-06-*"}, want: []string{"2024-06-01.txt", "2024-06-02.txt"}},
+		{name: "directory", only: []string{"trips"}, want: []string{"trips/rome.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, entries)
+			defer cleanup()
+
+			j := tj.open(t, Options{Only: tt.only})
+			if err := j.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			checklist, err := j.readChecklist()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, file := range checklist.files {
+				got = append(got, filepath.ToSlash(j.relPath(file.path)))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unlocked %q, want %q", got, tt.want)
+			}
+
+			for _, name := range tt.want {
+				tj.write(t, name, "edited")
+			}
+			if err := tj.open(t, Options{}).Lock(); err != nil {
+				t.Fatal(err)
+			}
+			tj.expectFiles(t, "2024-05-31.txt.gpg", "2024-06-01.txt.gpg", "2024-06-02.txt.gpg", "trips/rome.txt.gpg")
+			for _, name := range tt.want {
+				if got := tj.decrypt(t, name+".gpg"); got != "edited" {
+					t.Errorf("%s.gpg holds %q, want %q", name, got, "edited")
+				}
+			}
+		})
+	}
+
+	tj, cleanup := newTestJournal(t, entries)
+	defer cleanup()
+	if err := tj.open(t, Options{Only: []string{"2023-*"}}).Unlock(); err == nil || !strings.Contains(err.Error(), "no entries") {
+		t.Errorf("got error %v, want no entries to match", err)
+	}
+}
+
+func TestTopLevel(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "attachments/scan.pdf": "scan"})
+	defer cleanup()
+
+	j := tj.open(t, Options{TopLevel: true})
+	if len(j.Files) != 1 || j.Files[0].plain != tj.path("a.txt") {
+		t.Fatalf("discovered %v, want a.txt only", j.Files)
+	}
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "attachments/new.txt", "left alone")
+	if err := tj.open(t, Options{TopLevel: true}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "attachments/new.txt", "attachments/scan.pdf.gpg")
+}
+
+func TestExcludeExt(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "a.txt.sig": "signature", "b.SIG": "not excluded"})
+	defer cleanup()
+
+	opts := Options{ExcludeExt: []string{"sig"}}
+	j := tj.open(t, opts)
+	var names []string
+	for _, file := range j.Files {
+		names = append(names, j.relPath(file.enc))
+	}
+	if want := []string{"a.txt.gpg", "b.SIG.gpg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("discovered %q, want %q", names, want)
+	}
+
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "c.txt.sig", "new signature")
+	if err := tj.open(t, opts).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "a.txt.sig.gpg", "b.SIG.gpg", "c.txt.sig")
+}
+
+func TestUnlockBatches(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "c.txt": "c", "d.txt": "d", "e.txt": "e"})
+	defer cleanup()
+	tj.fake.encrypt(t, tj.path("b.txt.gpg"), "b", defaultFakeKeys[1].keyID())
+
+	err := tj.open(t, Options{BatchSize: 2, KeepGoing: true, Jobs: 1}).Unlock()
+	if err == nil || !strings.Contains(err.Error(), "1 of 5 files failed (b.txt.gpg)") {
+		t.Errorf("got error %v, want b.txt.gpg reported as failed", err)
+	}
+
+	// the last file is left alone in its batch, and decrypted by itself
+	var batches [][]string
+	for _, args := range tj.fake.runs(t) {
+		if hasArg(args, "--decrypt-files") {
+			batches = append(batches, args[len(args)-2:])
+		} else {
+			batches = append(batches, args[len(args)-1:])
+		}
+	}
+	want := [][]string{
+		{tj.path("a.txt.gpg"), tj.path("b.txt.gpg")},
+		{tj.path("c.txt.gpg"), tj.path("d.txt.gpg")},
+		{tj.path("e.txt.gpg")},
+	}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("decrypted batches %q, want %q", batches, want)
+	}
+	tj.expectFiles(t, ".check", "a.txt", ".a.txt.gpg", "b.txt.gpg", "c.txt", ".c.txt.gpg", "d.txt", ".d.txt.gpg", "e.txt", ".e.txt.gpg")
+	if got := tj.read(t, "e.txt"); got != "e" {
+		t.Errorf("e.txt holds %q, want %q", got, "e")
+	}
+}
+
+func TestUnlockJobs(t *testing.T) {
+	entries := benchmarkEntries(20)
+	tj, cleanup := newTestJournal(t, entries)
+	defer cleanup()
+
+	if err := tj.open(t, Options{Jobs: 4}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range entries {
+		if got := tj.read(t, name); got != content {
+			t.Errorf("%s holds %q, want %q", name, got, content)
+		}
+	}
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// whichever failure a worker meets first, the first in walk order is
+	// the one reported
+	tj.fake.encrypt(t, tj.path("0005.txt.gpg"), "5", defaultFakeKeys[1].keyID())
+	tj.fake.encrypt(t, tj.path("0015.txt.gpg"), "15", defaultFakeKeys[1].keyID())
+	err := tj.open(t, Options{Jobs: 4}).Unlock()
+	var derr *DecryptError
+	if !errors.As(err, &derr) || derr.File != tj.path("0005.txt.gpg") {
+		t.Errorf("got error %v, want 0005.txt.gpg's", err)
+	}
+}
+
+// benchmarkEntries returns n small entries for a benchmark journal.
+func benchmarkEntries(n int) map[string]string {
+	entries := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("%04d.txt", i)] = fmt.Sprintf("entry %d", i)
+	}
+	return entries
+}
+
+// benchmarkUnlock measures unlocking a journal of 64 entries with opts,
+// locking it again between runs.
+func benchmarkUnlock(b *testing.B, opts Options) {
+	tj, cleanup := newTestJournal(b, benchmarkEntries(64))
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tj.open(b, opts).Unlock(); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		if err := tj.open(b, Options{}).Lock(); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkUnlockJobs(b *testing.B) {
+	for _, jobs := range []int{1, 4, 16} {
+		jobs := jobs
+		b.Run(fmt.Sprintf("jobs-%d", jobs), func(b *testing.B) {
+			benchmarkUnlock(b, Options{Jobs: jobs})
+		})
+	}
+}
+
+func BenchmarkUnlockBatchSize(b *testing.B) {
+	for _, batchSize := range []int{0, 8, 64} {
+		batchSize := batchSize
+		b.Run(fmt.Sprintf("batch-%d", batchSize), func(b *testing.B) {
+			benchmarkUnlock(b, Options{BatchSize: batchSize, Jobs: 1})
+		})
+	}
+}
+
+func BenchmarkLock(b *testing.B) {
+	// a large journal of which one entry changed, so that the time is spent
+	// sorting files rather than encrypting them
+	tj, cleanup := newTestJournal(b, benchmarkEntries(256))
+	defer cleanup()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := tj.open(b, Options{BatchSize: 64}).Unlock(); err != nil {
+			b.Fatal(err)
+		}
+		tj.write(b, "0000.txt", fmt.Sprintf("edit %d", i))
+		b.StartTimer()
+
+		if err := tj.open(b, Options{}).Lock(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestInitRecipients(t *testing.T) {
+	dir, cleanup := tempJournal(t, map[string]string{
+		ConfigFile:   `recipient = ["config@example.com"]`,
+		"recipients": "# team\nfile@example.com\n\nother@example.com\n",
+		"empty":      "# nobody yet\n",
+	})
+	defer cleanup()
+	bare, cleanupBare := tempJournal(t, nil)
+	defer cleanupBare()
+
+	tests := []struct {
+		name string
+		dir  string
+		opts Options
+		want []string
+		err  string
+	}{
+		{name: "flag", dir: dir, opts: Options{Recipients: []string{"flag@example.com"}, RecipientFile: filepath.Join(dir, "recipients")}, want: []string{"flag@example.com"}},
+		{name: "recipient file", dir: dir, opts: Options{RecipientFile: filepath.Join(dir, "recipients")}, want: []string{"file@example.com", "other@example.com"}},
+		{name: "empty recipient file", dir: dir, opts: Options{RecipientFile: filepath.Join(dir, "empty")}, err: "lists no recipients"},
+		{name: "missing recipient file", dir: dir, opts: Options{RecipientFile: filepath.Join(dir, "missing")}, err: "Error reading recipient file"},
+		{name: "config", dir: dir, want: []string{"config@example.com"}},
+		{name: "none", dir: bare},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InitRecipients(tt.dir, tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got recipients %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitJournal(t *testing.T) {
+	fake, cleanup := newFakeGPG(t)
+	defer cleanup()
+	dir := filepath.Join(fake.dir, "journal")
+	opts := Options{GPGCommand: fake.command(), Recipients: []string{"me@example.com", "two@example.com"}}
+
+	if err := InitJournal(dir, opts, false); err != nil {
+		t.Fatal(err)
+	}
+	gpgid := filepath.Join(dir, ".gpgid")
+	content, err := ioutil.ReadFile(gpgid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "me@example.com\ntwo@example.com\n"; string(content) != want {
+		t.Errorf(".gpgid holds %q, want %q", content, want)
+	}
+
+	opts.Recipients = []string{"me@example.com"}
+	if err := InitJournal(dir, opts, false); err == nil || !strings.Contains(err.Error(), "already initialised") {
+		t.Errorf("got error %v, want the journal reported initialised", err)
+	}
+	if err := InitJournal(dir, opts, true); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(gpgid); string(content) != "me@example.com\n" {
+		t.Errorf(".gpgid holds %q after --force, want the new recipient", content)
+	}
+
+	opts.Recipients = []string{"nobody@example.com"}
+	if err := InitJournal(dir, opts, true); err == nil {
+		t.Error("initialised a journal for a recipient without a key")
+	}
+	if content, _ := ioutil.ReadFile(gpgid); string(content) != "me@example.com\n" {
+		t.Errorf(".gpgid holds %q after a failed init, want it unchanged", content)
+	}
+	if err := InitJournal(filepath.Join(fake.dir, "none"), Options{GPGCommand: fake.command()}, false); err == nil || !strings.Contains(err.Error(), "no recipient given") {
+		t.Errorf("got error %v, want no recipient reported", err)
+	}
+}
+
+func TestLockConfirmAbove(t *testing.T) {
+	tests := []struct {
+		name    string
+		edits   []string
+		confirm error
+		asked   bool
+		err     string
+	}{
+		{name: "at the threshold", edits: []string{"a.txt"}},
+		{name: "above the threshold, confirmed", edits: []string{"a.txt", "b.txt"}, asked: true},
+		{name: "above the threshold, cancelled", edits: []string{"a.txt", "b.txt"}, confirm: errors.New("Lock cancelled"), asked: true, err: "Lock cancelled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+			defer cleanup()
+			if err := tj.open(t, Options{}).Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.edits {
+				tj.write(t, name, name+" edited")
+			}
+
+			var asked []string
+			err := tj.open(t, Options{ConfirmAbove: 1, Confirm: func(modified, deleted []string) error {
+				asked = append(modified, deleted...)
+				return tt.confirm
+			}}).Lock()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				if !exists(tj.path(".check")) {
+					t.Error("journal locked after the lock was cancelled")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if tt.asked && !reflect.DeepEqual(asked, tt.edits) {
+				t.Errorf("asked to confirm %v, want %v", asked, tt.edits)
+			}
+			if !tt.asked && asked != nil {
+				t.Errorf("asked to confirm %v, want no prompt", asked)
+			}
+		})
+	}
+}
+
+func TestLockConfirmAboveWithoutConfirm(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer cleanup()
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.remove(t, "a.txt")
+	tj.write(t, "b.txt", "b edited")
+
+	err := tj.open(t, Options{ConfirmAbove: 1}).Lock()
+	if err == nil || !strings.Contains(err.Error(), "1 files modified and 1 deleted, more than 1") {
+		t.Errorf("got error %v, want the lock refused", err)
+	}
+}
+
+// entryStates returns the state of each of entries by path.
+func entryStates(entries []Entry) map[string]string {
+	states := make(map[string]string)
+	for _, entry := range entries {
+		states[entry.Path] = entry.State
+	}
+	return states
+}
+
+func TestStatusEntries(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	defer cleanup()
+
+	entries, err := tj.open(t, Options{}).StatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.txt.gpg": "locked", "b.txt.gpg": "locked", "c.txt.gpg": "locked"}
+	if got := entryStates(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v of a locked journal, want %v", got, want)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a edited")
+	tj.remove(t, "c.txt")
+	tj.write(t, "d.txt", "new")
+
+	entries, err = tj.open(t, Options{}).StatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"a.txt": "modified", "b.txt": "unchanged", "c.txt": "deleted", "d.txt": "new"}
+	if got := entryStates(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+	for _, entry := range entries {
+		if (entry.Modified == nil) != (entry.State == "deleted") {
+			t.Errorf("%s: got modification time %v, want one unless it is deleted", entry.Path, entry.Modified)
+		}
+	}
+}
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t testing.TB, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		content, _ := ioutil.ReadAll(r)
+		out <- string(content)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestVerbose(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+		defer cleanup()
+
+		out := captureStdout(t, func() {
+			if err := tj.open(t, Options{Verbose: verbose}).Unlock(); err != nil {
+				t.Error(err)
+			}
+			tj.write(t, "a.txt", "a edited")
+			if err := tj.open(t, Options{Verbose: verbose}).Lock(); err != nil {
+				t.Error(err)
+			}
+		})
+
+		executed := strings.Contains(out, "Executing "+tj.fake.command()+" ")
+		if executed != verbose {
+			t.Errorf("verbose %v: got output:\n%s", verbose, out)
+		}
+		if !verbose && strings.Contains(out, "me@example.com") {
+			t.Errorf("got output naming the recipient without --verbose:\n%s", out)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	tests := []struct {
+		name string
+		text string // appended by the editor
+		want string
+		err  string
+	}{
+		{name: "edited", text: " edited", want: "a edited"},
+		{name: "unchanged", text: "", want: "a"},
+		{name: "editor failed", text: "fail", want: "a", err: "Editor exited with an error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			edits, restore := fakeEditor(t, tt.text)
+			defer restore()
+			encrypted := tj.read(t, "a.txt.gpg")
+
+			err := tj.open(t, Options{}).Edit("a.txt")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := tj.decrypt(t, "a.txt.gpg"); got != tt.want {
+				t.Errorf("a.txt.gpg holds %q, want %q", got, tt.want)
+			}
+			if tt.want == "a" && tj.read(t, "a.txt.gpg") != encrypted {
+				t.Error("a.txt.gpg re-encrypted without a change")
+			}
+			tj.expectFiles(t, "a.txt.gpg")
+
+			// the editor was given the plaintext outside the journal, and it
+			// is gone once the edit is done
+			made := edits(t)
+			if len(made) != 1 || made[0].Content != "a" || strings.HasPrefix(made[0].Path, tj.dir) || exists(made[0].Path) {
+				t.Errorf("got edits %+v, want one of the plaintext in a temporary file since removed", made)
+			}
+		})
+	}
+}
+
+func TestEncryptFailureLeavesEntry(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(j *Journal, src string) error
+	}{
+		{name: "edit", run: func(j *Journal, src string) error { return j.Edit("a.txt") }},
+		{name: "new", run: func(j *Journal, src string) error { return j.New("b.txt") }},
+		{name: "add", run: func(j *Journal, src string) error { return j.Add(src, "b.txt", false) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			_, restore := fakeEditor(t, " edited")
+			defer restore()
+			src := filepath.Join(tj.fake.dir, "notes.txt")
+			if err := ioutil.WriteFile(src, []byte("notes"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			before := tj.snapshot(t)
+
+			j := tj.open(t, Options{})
+			j.crypter = truncatingCrypter{j.crypter}
+			if err := tt.run(j, src); err == nil || !strings.Contains(err.Error(), "Error encrypting file") {
+				t.Fatalf("got error %v, want the encryption failure", err)
+			}
+			if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+				t.Errorf("failed %s changed the journal from %q to %q", tt.name, before, after)
+			}
+			if !exists(src) {
+				t.Error("removed the plaintext of a file that was not added")
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+	defer cleanup()
+
+	if _, err := tj.open(t, Options{}).Diff(); err == nil || !strings.Contains(err.Error(), "is not unlocked") {
+		t.Errorf("got error %v diffing a locked journal, want it reported locked", err)
+	}
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := tj.open(t, Options{}).Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("got changes %v straight after unlock, want none", changes)
+	}
+
+	// touching a file without changing it is not a change
+	tj.write(t, "a.txt", "a")
+	tj.write(t, "sub/c.txt", "c edited")
+	changes, err = tj.open(t, Options{}).Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join("sub", "c.txt")}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %v, want %v", changes, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+	tj.write(t, ".template", "# {{date}}\n")
+	_, restore := fakeEditor(t, "today")
+	defer restore()
+	today := time.Now().Format("2006-01-02")
+
+	if err := tj.open(t, Options{DateLayout: "2006-01-02"}).New(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{DateLayout: "2006-01-02"}).New("trips/paris.md"); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, ".template", today+".gpg", "a.txt.gpg", "trips/paris.md.gpg")
+	for _, name := range []string{today + ".gpg", "trips/paris.md.gpg"} {
+		if got, want := tj.decrypt(t, name), "# "+today+"\ntoday"; got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+
+	if err := tj.open(t, Options{}).New("a.txt"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got error %v, want a.txt reported existing", err)
+	}
+
+	// an entry left as the template is not saved
+	_, restoreUnchanged := fakeEditor(t, "")
+	defer restoreUnchanged()
+	if err := tj.open(t, Options{}).New("empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(tj.path("empty.txt.gpg")) {
+		t.Error("saved an entry left unchanged")
+	}
+}
+
+func TestNewInUnlockedJournal(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+	_, restore := fakeEditor(t, "new")
+	defer restore()
+
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.open(t, Options{}).New("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "b.txt"); got != "new" {
+		t.Errorf("b.txt holds %q, want %q", got, "new")
+	}
+
+	// lock encrypts it like any other new file
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg")
+}
+
+func TestBackendSelection(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		opts  Options
+		want  string // the crypter's type
+		err   string
+	}{
+		{
+			name:  "gpgid",
+			files: map[string]string{".gpgid": "me@example.com\n"},
+			want:  "*journal.GPGCrypter",
+		},
+		{
+			name:  "age recipients only",
+			files: map[string]string{AgeRecipientsFile: "age1me\n"},
+			want:  "*journal.AgeCrypter",
+		},
+		{
+			name:  "both prefer gpg",
+			files: map[string]string{".gpgid": "me@example.com\n", AgeRecipientsFile: "age1me\n"},
+			want:  "*journal.GPGCrypter",
+		},
+		{
+			name:  "explicit age",
+			files: map[string]string{".gpgid": "me@example.com\n", AgeRecipientsFile: "age1me\n"},
+			opts:  Options{Backend: "age"},
+			want:  "*journal.AgeCrypter",
+		},
+		{
+			name:  "age without recipients",
+			files: map[string]string{".gpgid": "me@example.com\n"},
+			opts:  Options{Backend: "age"},
+			err:   ErrNoAgeRecipients.Error(),
+		},
+		{
+			name:  "age with a passphrase",
+			files: map[string]string{AgeRecipientsFile: "age1me\n"},
+			opts:  Options{Backend: "age", Passphrase: "secret"},
+			err:   "a passphrase is not used by the age backend",
+		},
+		{
+			name:  "unknown backend",
+			files: map[string]string{".gpgid": "me@example.com\n"},
+			opts:  Options{Backend: "rot13"},
+			err:   "unknown backend rot13",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, cleanup := tempJournal(t, tt.files)
+			defer cleanup()
+
+			j, err := Open(dir, tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%T", j.crypter); got != tt.want {
+				t.Errorf("got crypter %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlockLockAge(t *testing.T) {
+	fake, removeFake := newFakeAge(t)
+	defer removeFake()
+	dir, removeDir := tempJournal(t, map[string]string{AgeRecipientsFile: "# me\nage1me\n"})
+	defer removeDir()
+	tj := &testJournal{dir: dir}
+	fake.encrypt(t, tj.path("a.txt"+AgeFileExt), "a", "age1me")
+
+	j, err := Open(dir, Options{AgeCommand: fake.command()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "a.txt"); got != "a" {
+		t.Fatalf("unlocked %q, want %q", got, "a")
+	}
+
+	tj.write(t, "a.txt", "a, edited")
+	tj.write(t, "b.txt", "b")
+	j, err = Open(dir, Options{AgeCommand: fake.command()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, AgeRecipientsFile, "a.txt.age", "b.txt.age")
+	for name, want := range map[string]string{"a.txt.age": "a, edited", "b.txt.age": "b"} {
+		plain, recipients := fake.decrypt(t, tj.path(name))
+		if plain != want || !reflect.DeepEqual(recipients, []string{"age1me"}) {
+			t.Errorf("locked %s as %q to %v, want %q to age1me", name, plain, recipients, want)
+		}
+	}
+}
+
+func TestGrep(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{
+		"a.txt":     "walked the dog\nrained all day\nThe dog slept\n",
+		"sub/b.txt": "no pets here\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+	}{
+		{
+			name:    "matching lines with line numbers",
+			pattern: "dog",
+			want:    "a.txt:1:walked the dog\na.txt:3:The dog slept\n",
+		},
+		{
+			name:    "case insensitive",
+			pattern: "(?i)^the|PETS",
+			want:    "a.txt:3:The dog slept\nsub/b.txt:1:no pets here\n",
+		},
+		{
+			name:    "no matches",
+			pattern: "cat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tj.open(t, Options{}).Grep(regexp.MustCompile(tt.pattern), &buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("grep wrote %q, want %q", buf.String(), tt.want)
+			}
+			tj.expectFiles(t, "a.txt.gpg", "sub/b.txt.gpg")
+		})
+	}
+}
+
+func TestLockRecipientOverride(t *testing.T) {
+	me, two := defaultFakeKeys[0].keyID(), defaultFakeKeys[1].keyID()
+	tests := []struct {
+		name string
+		opts Options
+		want map[string][]string
+	}{
+		{
+			name: "gpgid",
+			want: map[string][]string{"a.txt.gpg": {me}, "shared/b.txt.gpg": {two, me}},
+		},
+		{
+			name: "recipients replace every gpgid",
+			opts: Options{Recipients: []string{"two@example.com"}},
+			want: map[string][]string{"a.txt.gpg": {two}, "shared/b.txt.gpg": {two}},
+		},
+		{
+			name: "recipient file",
+			opts: Options{RecipientFile: "recipients"},
+			want: map[string][]string{"a.txt.gpg": {two}, "shared/b.txt.gpg": {two}},
+		},
+		{
+			name: "recipients before the recipient file",
+			opts: Options{Recipients: []string{"me@example.com"}, RecipientFile: "recipients"},
+			want: map[string][]string{"a.txt.gpg": {me}, "shared/b.txt.gpg": {me}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"seed.txt": "seed"})
+			defer cleanup()
+			tj.write(t, "shared/.gpgid", "two@example.com\nme@example.com\n")
+			if err := tj.open(t, Options{}).Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			tj.write(t, "a.txt", "a")
+			tj.write(t, "shared/b.txt", "b")
+			recipients := filepath.Join(tj.fake.dir, "recipients")
+			if err := ioutil.WriteFile(recipients, []byte("# the other key\ntwo@example.com\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := tt.opts
+			if opts.RecipientFile != "" {
+				opts.RecipientFile = recipients
+			}
+			if err := tj.open(t, opts).Lock(); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if _, got := tj.fake.decrypt(t, tj.path(name)); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s is encrypted to %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt bool
+		want    string
+		err     string
+	}{
+		{
+			name: "every file decrypts",
+			want: "Verified journal: 2 files decrypted successfully\n",
+		},
+		{
+			name:    "a corrupt file",
+			corrupt: true,
+			want:    "FAIL b.txt.gpg: ",
+			err:     "1 of 2 files could not be decrypted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+			defer cleanup()
+			if tt.corrupt {
+				tj.write(t, "b.txt.gpg", "garbage")
+			}
+
+			var buf bytes.Buffer
+			err := tj.open(t, Options{}).Verify(&buf)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.err)
+			}
+			if !strings.HasPrefix(buf.String(), tt.want) || strings.Contains(buf.String(), "a.txt") {
+				t.Errorf("verify wrote %q, want %q", buf.String(), tt.want)
+			}
+			tj.expectFiles(t, "a.txt.gpg", "b.txt.gpg")
+		})
+	}
+}
+
+func TestCat(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "dear diary\n", "sub/b.txt": "b\n"})
+	defer cleanup()
+	tj.write(t, "corrupt.txt.gpg", "garbage")
+
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+		err   string
+	}{
+		{name: "plain name", entry: "a.txt", want: "dear diary\n"},
+		{name: "encrypted name", entry: "a.txt.gpg", want: "dear diary\n"},
+		{name: "subdirectory", entry: "sub/b.txt", want: "b\n"},
+		{name: "absolute", entry: tj.path("sub/b.txt.gpg"), want: "b\n"},
+		{name: "missing", entry: "c.txt", err: "c.txt is not an encrypted file"},
+		{name: "corrupt", entry: "corrupt.txt", err: "Error decrypting file " + tj.path("corrupt.txt.gpg")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tj.open(t, Options{}).Cat(tt.entry, &buf)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("cat wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+	tj.expectFiles(t, "a.txt.gpg", "corrupt.txt.gpg", "sub/b.txt.gpg")
+}
+
+func TestSession(t *testing.T) {
+	tests := []struct {
+		name string
+		text string // written by the editor
+		want []string
+		err  string
+	}{
+		{name: "new entry", text: "b", want: []string{"a.txt.gpg", "new.txt.gpg"}},
+		{name: "nothing written", want: []string{"a.txt.gpg"}},
+		{name: "editor failed", text: "fail", want: []string{"a.txt.gpg"}, err: "Editor exited with an error"},
+		{name: "interrupted", text: "interrupt", want: []string{"a.txt.gpg"}, err: "Editor exited with an error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			edits, restore := fakeEditor(t, tt.text)
+			defer restore()
+
+			err := tj.open(t, Options{}).Session()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			// the journal was unlocked while the editor ran on it, and is
+			// locked again once it exits
+			made := edits(t)
+			if len(made) != 1 || made[0].Path != tj.dir || !strings.Contains(made[0].Content, "a.txt") {
+				t.Errorf("got edits %+v, want one of the unlocked journal", made)
+			}
+			tj.expectFiles(t, tt.want...)
+			if got := tj.decrypt(t, "a.txt.gpg"); got != "a" {
+				t.Errorf("a.txt.gpg holds %q, want %q", got, "a")
+			}
+			if tt.text == "b" {
+				if got := tj.decrypt(t, "new.txt.gpg"); got != "b" {
+					t.Errorf("new.txt.gpg holds %q, want %q", got, "b")
+				}
+			}
+		})
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+	tmp, removeTmp := tempJournal(t, map[string]string{"file": ""})
+	defer removeTmp()
+
+	t.Run("edit", func(t *testing.T) {
+		edits, restore := fakeEditor(t, " edited")
+		defer restore()
+
+		if err := tj.open(t, Options{TempDir: tmp}).Edit("a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		made := edits(t)
+		if len(made) != 1 || filepath.Dir(made[0].Path) != tmp {
+			t.Errorf("got edits %+v, want one of a temporary file in %s", made, tmp)
+		}
+		if got := tj.decrypt(t, "a.txt.gpg"); got != "a edited" {
+			t.Errorf("a.txt.gpg holds %q, want %q", got, "a edited")
+		}
+	})
+
+	tests := []struct {
+		name string
+		dir  string
+		err  string
+	}{
+		{name: "missing", dir: filepath.Join(tmp, "missing"), err: "no such file or directory"},
+		{name: "not a directory", dir: filepath.Join(tmp, "file"), err: "is not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(tj.dir, Options{GPGCommand: tj.fake.command(), TempDir: tt.dir})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+
+	if files, err := ioutil.ReadDir(tmp); err != nil || len(files) != 1 {
+		t.Errorf("temporary directory holds %v, error %v, want the plaintext removed", files, err)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	defer cleanup()
+
+	render := func(t *testing.T, format string) string {
+		t.Helper()
+
+		summary, err := tj.open(t, Options{}).Summary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := template.Must(template.New("status").Parse(format)).Execute(&buf, summary); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	if got := render(t, DefaultStatusFormat); got != "locked" {
+		t.Errorf("locked journal rendered %q, want %q", got, "locked")
+	}
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.write(t, "a.txt", "a, edited")
+	tj.remove(t, "b.txt")
+	tj.write(t, "d.txt", "d")
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{name: "default", format: DefaultStatusFormat, want: "3/3 unlocked, 1 modified, 1 new"},
+		{name: "counts", format: "{{.Total}} {{.Unlocked}} {{.Unchanged}} {{.Modified}} {{.New}} {{.Deleted}} {{.Locked}}", want: "3 3 1 1 1 1 false"},
+		{name: "entries", format: `{{range .Entries}}{{.Name}}={{.State}} {{end}}`, want: "a.txt=modified c.txt=unchanged d.txt=new b.txt=deleted "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(t, tt.format); got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	outside, removeOutside := tempJournal(t, nil)
+	defer removeOutside()
+
+	// whether or not they are followed, the links give no entries of their
+	// own, and survive unlocking and locking the files they point to
+	for _, opts := range []Options{{}, {FollowSymlinks: true}} {
+		t.Run(fmt.Sprintf("follow %t", opts.FollowSymlinks), func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+			defer cleanup()
+			tj.fake.encrypt(t, filepath.Join(outside, "secret.txt.gpg"), "secret", defaultFakeKeys[0].keyID())
+			links := map[string]string{
+				"link.txt.gpg":     "a.txt.gpg",
+				"sub/link.txt.gpg": "b.txt.gpg",
+				"outside.txt.gpg":  filepath.Join(outside, "secret.txt.gpg"),
+			}
+			for link, target := range links {
+				if err := os.Symlink(target, tj.path(link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			j := tj.open(t, opts)
+			var got []string
+			for _, file := range j.Files {
+				got = append(got, j.relPath(file.plain))
+			}
+			if want := []string{"a.txt", filepath.Join("sub", "b.txt")}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got entries %q, want %q", got, want)
+			}
+
+			if err := j.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(tj.path("link.txt")); !os.IsNotExist(err) {
+				t.Errorf("unlocked link.txt, error %v, want the link skipped", err)
+			}
+			if err := tj.open(t, opts).Lock(); err != nil {
+				t.Fatal(err)
+			}
+			for link, target := range links {
+				if got, err := os.Readlink(tj.path(link)); err != nil || got != target {
+					t.Errorf("%s links to %q, error %v, want %q", link, got, err, target)
+				}
+			}
+			if got := tj.decrypt(t, "link.txt.gpg"); got != "a" {
+				t.Errorf("link.txt.gpg reads %q, want a.txt.gpg's %q", got, "a")
+			}
+			if plain, _ := tj.fake.decrypt(t, filepath.Join(outside, "secret.txt.gpg")); plain != "secret" {
+				t.Errorf("the file outside the journal holds %q, want it untouched", plain)
+			}
+		})
+	}
+}
+
+func TestResolveEditor(t *testing.T) {
+	bin, cleanup := tempJournal(t, nil)
+	defer cleanup()
+	for _, name := range []string{"visual", "editor", "configured", "vi", "notepad"} {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer setenv(map[string]string{"PATH": bin})()
+	fallback := "vi"
+	if runtime.GOOS == "windows" {
+		fallback = "notepad"
+	}
+
+	tests := []struct {
+		name           string
+		visual, editor string
+		configured     string
+		want           []string
+		err            string
+	}{
+		{name: "visual first", visual: "visual -f", editor: "editor", configured: "configured", want: []string{"visual", "-f"}},
+		{name: "then editor", editor: "editor", configured: "configured", want: []string{"editor"}},
+		{name: "then configured", configured: "configured --wait", want: []string{"configured", "--wait"}},
+		{name: "blank is unset", visual: "  ", configured: "configured", want: []string{"configured"}},
+		{name: "fallback", want: []string{fallback}},
+		{name: "missing editor", visual: "missing", editor: "editor", err: "cannot run editor missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setenv(map[string]string{"VISUAL": tt.visual, "EDITOR": tt.editor})()
+
+			got, err := resolveEditor(tt.configured)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %q, error %v, want an error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got editor %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     string
+		keepPlain bool
+		want      string // the encrypted entry created
+		err       string
+	}{
+		{name: "moved in", entry: "notes.txt", want: "notes.txt.gpg"},
+		{name: "keep plain", entry: "notes.txt", keepPlain: true, want: "notes.txt.gpg"},
+		{name: "subdirectory", entry: "imported/notes.txt", want: "imported/notes.txt.gpg"},
+		{name: "existing entry", entry: "a.txt", err: "Entry a.txt already exists"},
+		{name: "outside the journal", entry: "../notes.txt", err: "is outside the journal"},
+		{name: "already encrypted", entry: "notes.txt.gpg", err: "is already encrypted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			src := filepath.Join(tj.fake.dir, "notes.txt")
+			if err := ioutil.WriteFile(src, []byte("migrated notes\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := tj.open(t, Options{}).Add(src, tt.entry, tt.keepPlain)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				tj.expectFiles(t, "a.txt.gpg")
+				if !exists(src) {
+					t.Error("removed the plaintext of a file that was not added")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tj.expectFiles(t, "a.txt.gpg", tt.want)
+			if got := tj.decrypt(t, tt.want); got != "migrated notes\n" {
+				t.Errorf("%s holds %q, want %q", tt.want, got, "migrated notes\n")
+			}
+			if exists(src) != tt.keepPlain {
+				t.Errorf("plaintext left behind is %t, want %t", exists(src), tt.keepPlain)
+			}
+		})
+	}
+}
+
+func TestAddToUnlockedJournal(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+	defer cleanup()
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tj.fake.dir, "notes.txt")
+	if err := ioutil.WriteFile(src, []byte("migrated notes\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tj.open(t, Options{}).Add(src, "notes.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "notes.txt"); got != "migrated notes\n" || exists(src) {
+		t.Errorf("added %q, plaintext left behind %t, want the plaintext moved in for lock", got, exists(src))
+	}
+
+	if err := tj.open(t, Options{}).Lock(); err != nil {
+		t.Fatal(err)
+	}
+	tj.expectFiles(t, "a.txt.gpg", "notes.txt.gpg")
+	if got := tj.decrypt(t, "notes.txt.gpg"); got != "migrated notes\n" {
+		t.Errorf("notes.txt.gpg holds %q, want %q", got, "migrated notes\n")
+	}
+}
+
+func TestUnlockOutDir(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/deep/b.txt": "b"})
+	defer cleanup()
+	out, removeOut := tempJournal(t, nil)
+	defer removeOut()
+	before := tj.snapshot(t)
+
+	if err := tj.open(t, Options{OutDir: out}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "a", "sub/deep/b.txt": "b"} {
+		content, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || string(content) != want {
+			t.Errorf("%s in the out dir holds %q, error %v, want %q", name, content, err, want)
+		}
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("journal changed from %v to %v, want it left locked", before, after)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{name: "existing files", opts: Options{OutDir: out}, err: "already exists; pass --force"},
+		{name: "inside the journal", opts: Options{OutDir: tj.path("sub")}, err: "must be outside the journal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tj.open(t, tt.opts).Unlock()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+
+	if err := tj.open(t, Options{OutDir: out, Force: true}).Unlock(); err != nil {
+		t.Errorf("got error %v unlocking over the out dir with force", err)
+	}
+	if err := tj.open(t, Options{OutDir: out}).Lock(); err == nil || !strings.Contains(err.Error(), "read-only copy") {
+		t.Errorf("got error %v locking an out dir, want it refused", err)
+	}
+}
+
+func TestUnlockDecryptError(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose %t", verbose), func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			tj.fake.encrypt(t, tj.path("b.txt.gpg"), "b", defaultFakeKeys[1].keyID())
+
+			var err error
+			captureStdout(t, func() { err = tj.open(t, Options{Verbose: verbose}).Unlock() })
+			var derr *DecryptError
+			if !errors.As(err, &derr) {
+				t.Fatalf("got error %v, want a DecryptError", err)
+			}
+			if derr.File != tj.path("b.txt.gpg") || derr.Command != tj.fake.command() || !errors.Is(err, ErrNoSecretKey) {
+				t.Errorf("got %+v, want the file, command and ErrNoSecretKey of b.txt.gpg", derr)
+			}
+			if !strings.Contains(err.Error(), tj.path("b.txt.gpg")) || !strings.Contains(err.Error(), tj.fake.command()) {
+				t.Errorf("got error %q, want it to name the file and gpg command", err)
+			}
+			if hasArgs := len(derr.Args) > 0 && hasArg(derr.Args, tj.path("b.txt.gpg")); hasArgs != verbose {
+				t.Errorf("got arguments %q, want them recorded only when verbose", derr.Args)
+			}
+		})
+	}
+}
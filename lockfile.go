@@ -0,0 +1,70 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LockFile names the file in a journal directory that Unlock and Lock hold
+// an advisory lock on while they run, so that two journal processes never
+// work on the same journal at once. The lock is released by the system when
+// its holder exits, so a file left behind by a crashed process does not
+// keep the journal locked.
+var LockFile = ".journal.lock"
+
+// errLockHeld is returned by flock when another process holds the lock.
+var errLockHeld = errors.New("lock held by another process")
+
+// acquireLock takes the journal's LockFile, returning the func that releases
+// it. The func may be called more than once.
+func (j *Journal) acquireLock() (func(), error) {
+	if j.dryRun {
+		return func() {}, nil
+	}
+
+	path := filepath.Join(j.RootDir, LockFile)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating lock file %s: %s", path, err)
+		}
+
+		if err := flock(file); err != nil {
+			holder, _ := ioutil.ReadAll(file)
+			file.Close()
+			if err == errLockHeld {
+				return nil, fmt.Errorf("Error: journal %s is in use by another journal process (pid %s); wait for it to finish", j.RootDir, strings.TrimSpace(string(holder)))
+			}
+			return nil, fmt.Errorf("Error locking %s: %s", path, err)
+		}
+
+		// the previous holder removes the file on release, which leaves
+		// this lock on a file no other process can find, so start over
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Error locking %s: %s", path, err)
+		}
+		if current, err := os.Stat(path); err != nil || !os.SameFile(opened, current) {
+			file.Close()
+			continue
+		}
+
+		file.Truncate(0)
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+
+		released := false
+		return func() {
+			if released {
+				return
+			}
+			released = true
+			os.Remove(path)
+			file.Close()
+		}, nil
+	}
+}
@@ -0,0 +1,60 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+	j := &Journal{RootDir: dir}
+	path := filepath.Join(dir, LockFile)
+
+	release, err := j.acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(holder)); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want this process's pid", got)
+	}
+
+	if _, err := j.acquireLock(); err == nil || !strings.Contains(err.Error(), "in use by another journal process (pid "+strconv.Itoa(os.Getpid())+")") {
+		t.Errorf("got error %v, want the journal reported in use", err)
+	}
+
+	release()
+	release()
+	if exists(path) {
+		t.Error("lock file left after release")
+	}
+
+	again, err := j.acquireLock()
+	if err != nil {
+		t.Fatalf("acquiring a released lock: %s", err)
+	}
+	again()
+}
+
+func TestAcquireLockDryRun(t *testing.T) {
+	dir, cleanup := tempJournal(t, nil)
+	defer cleanup()
+	j := &Journal{RootDir: dir, dryRun: true}
+
+	release, err := j.acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if exists(filepath.Join(dir, LockFile)) {
+		t.Error("dry run created a lock file")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package journal
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes an exclusive advisory lock on file without waiting for it.
+func flock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLockHeld
+	}
+	return err
+}
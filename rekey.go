@@ -0,0 +1,145 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rekey re-encrypts every entry of the journal to recipients, then writes
+// them to the root .gpgid. With no recipients, entries are re-encrypted to
+// those .gpgid lists now, as after editing it by hand. Entries below a
+// subdirectory's own .gpgid keep its recipients either way, and are
+// reported so that it can be edited too.
+//
+// Entries are decrypted into memory only. Each is re-encrypted to a staging
+// file beside it, and the staged files replace the originals only once all
+// of them are written, so an interrupted rekey leaves every entry readable
+// and can simply be run again.
+func (j *Journal) Rekey(recipients []string) error {
+	gpg, ok := j.crypter.(*GPGCrypter)
+	if !ok {
+		return fmt.Errorf("Error: rekey is only supported by the gpg backend")
+	}
+	if gpg.Symmetric {
+		return fmt.Errorf("Error: a symmetric journal has no recipients to rekey")
+	}
+	// configured recipients take the place of .gpgid, so writing it would
+	// leave the entries encrypted to recipients it does not list
+	if len(recipients) > 0 && gpg.RootDir == "" {
+		return fmt.Errorf("Error: recipients from .journal, JOURNAL_RECIPIENT, --recipient or --recipient-file take the place of .gpgid, so rekey cannot replace it with --to")
+	}
+
+	release, err := j.acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if len(recipients) > 0 {
+		gpg.Recipients = recipients
+		ctx, cancel := j.context()
+		err := gpg.CheckRecipients(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Error: %s", err)
+		}
+	}
+
+	if j.dryRun {
+		for _, file := range j.Files {
+			fmt.Printf("Would re-encrypt %s to %s\n", file.encrypted(), strings.Join(gpg.recipientsFor(file.encrypted()), ", "))
+		}
+		return nil
+	}
+
+	// stage the new .gpgid along with the entries, so that it is only
+	// replaced once they are
+	gpgid := gpgidPath(j.RootDir)
+	if gpgid == "" {
+		gpgid = filepath.Join(j.RootDir, ".gpgid")
+	}
+	staged := make(map[string]string)
+	if len(recipients) > 0 {
+		content := strings.Join(recipients, "\n") + "\n"
+		if err := ioutil.WriteFile(rekeyStaging(gpgid), []byte(content), 0600); err != nil {
+			return fmt.Errorf("Error writing %s: %s", rekeyStaging(gpgid), err)
+		}
+		staged[gpgid] = rekeyStaging(gpgid)
+	}
+
+	discard := func() {
+		for _, staging := range staged {
+			os.Remove(staging)
+		}
+	}
+
+	for _, file := range j.Files {
+		enc := file.encrypted()
+		staging := rekeyStaging(enc)
+		ctx, cancel := j.context()
+		err := gpg.Reencrypt(ctx, enc, staging)
+		cancel()
+		if err != nil {
+			os.Remove(staging)
+			discard()
+			return fmt.Errorf("Error re-encrypting %s: %s", enc, err)
+		}
+		staged[enc] = staging
+	}
+
+	// entries first, so the old .gpgid stays until every entry is rekeyed
+	kept := make(map[string]int)
+	var keptOrder []string
+	for _, file := range j.Files {
+		enc := file.encrypted()
+		if err := os.Rename(staged[enc], enc); err != nil {
+			discard()
+			return fmt.Errorf("Error replacing %s: %s", enc, err)
+		}
+		delete(staged, enc)
+
+		if sub := gpg.gpgidFor(enc); sub != "" {
+			if kept[sub] == 0 {
+				keptOrder = append(keptOrder, sub)
+			}
+			kept[sub]++
+		}
+	}
+	if staging, ok := staged[gpgid]; ok {
+		if err := os.Rename(staging, gpgid); err != nil {
+			discard()
+			return fmt.Errorf("Error replacing %s: %s", gpgid, err)
+		}
+	}
+
+	err = j.updateEncChecklist(func(checklist *Checklist) error {
+		return j.hashEncrypted(checklist, j.Files)
+	})
+	if err != nil {
+		return err
+	}
+
+	rekeyed := len(j.Files)
+	for _, sub := range keptOrder {
+		rekeyed -= kept[sub]
+	}
+	if len(recipients) > 0 {
+		fmt.Printf("Rekeyed journal: %d files re-encrypted to %s\n", rekeyed, strings.Join(recipients, ", "))
+	} else {
+		fmt.Printf("Rekeyed journal: %d files re-encrypted to the recipients of %s\n", rekeyed, gpgid)
+	}
+	for _, sub := range keptOrder {
+		fmt.Printf("Kept %s: %d files re-encrypted to the recipients it lists\n", j.relPath(sub), kept[sub])
+	}
+	return nil
+}
+
+// rekeyStaging returns the hidden path beside path that Rekey writes its
+// replacement to. It does not carry the journal's extension, so an
+// interrupted rekey leaves nothing that is taken for an entry.
+func rekeyStaging(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".rekey")
+}
@@ -0,0 +1,133 @@
+package journal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	entries := map[string]string{"a.txt": "a", "sub/b.txt": "b"}
+	tj, cleanup := newTestJournal(t, entries)
+	defer cleanup()
+
+	if err := tj.open(t, Options{}).Rekey([]string{"me@example.com", "two@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries were decrypted in memory only
+	tj.expectFiles(t, "a.txt.gpg", "sub/b.txt.gpg")
+	if got, want := tj.read(t, ".gpgid"), "me@example.com\ntwo@example.com\n"; got != want {
+		t.Errorf(".gpgid holds %q, want %q", got, want)
+	}
+	wantRecipients := []string{defaultFakeKeys[0].keyID(), defaultFakeKeys[1].keyID()}
+	for name, content := range entries {
+		plain, recipients := tj.fake.decrypt(t, tj.path(name+".gpg"))
+		if plain != content {
+			t.Errorf("%s.gpg holds %q, want %q", name, plain, content)
+		}
+		if !reflect.DeepEqual(recipients, wantRecipients) {
+			t.Errorf("%s.gpg is encrypted to %v, want %v", name, recipients, wantRecipients)
+		}
+	}
+
+	// the rekeyed journal unlocks as before
+	if err := tj.open(t, Options{}).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tj.read(t, "sub/b.txt"); got != "b" {
+		t.Errorf("unlocked sub/b.txt holds %q, want %q", got, "b")
+	}
+}
+
+func TestRekeyFailureLeavesJournal(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "c.txt": "c"})
+	defer cleanup()
+	// b.txt.gpg cannot be decrypted, so the rekey stops part way
+	tj.fake.encrypt(t, tj.path("b.txt.gpg"), "b", defaultFakeKeys[1].keyID())
+	before := tj.snapshot(t)
+	gpgid := tj.read(t, ".gpgid")
+
+	err := tj.open(t, Options{}).Rekey([]string{"two@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "Error re-encrypting "+tj.path("b.txt.gpg")) {
+		t.Errorf("got error %v, want b.txt.gpg named", err)
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("rekey left %v, want the journal as it was", tj.files(t))
+	}
+	if got := tj.read(t, ".gpgid"); got != gpgid {
+		t.Errorf(".gpgid holds %q, want it unchanged", got)
+	}
+
+	if err := tj.open(t, Options{}).Rekey([]string{"nobody@example.com"}); err == nil {
+		t.Error("rekeyed to a recipient without a key")
+	}
+	if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("rekey left %v, want the journal as it was", tj.files(t))
+	}
+}
+
+func TestRekeyRefusesConfiguredRecipients(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		config string
+	}{
+		{name: "recipient", opts: Options{Recipients: []string{"me@example.com"}}},
+		{name: "recipient file", opts: Options{RecipientFile: "recipients"}},
+		{name: "config", config: "recipient = \"me@example.com\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a"})
+			defer cleanup()
+			tj.write(t, "recipients", "me@example.com\n")
+			if tt.opts.RecipientFile != "" {
+				tt.opts.RecipientFile = tj.path(tt.opts.RecipientFile)
+			}
+			if tt.config != "" {
+				tj.write(t, ConfigFile, tt.config)
+			}
+			before := tj.snapshot(t)
+
+			err := tj.open(t, tt.opts).Rekey([]string{"two@example.com"})
+			if err == nil || !strings.Contains(err.Error(), "--to") {
+				t.Errorf("got error %v, want --to refused", err)
+			}
+			if after := tj.snapshot(t); !reflect.DeepEqual(after, before) {
+				t.Errorf("rekey left %v, want the journal as it was", tj.files(t))
+			}
+		})
+	}
+}
+
+func TestRekeyReportsSubdirectoryGpgid(t *testing.T) {
+	tj, cleanup := newTestJournal(t, map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/c.txt": "c"})
+	defer cleanup()
+	tj.write(t, "sub/.gpgid", "me@example.com\n")
+
+	out := captureStdout(t, func() {
+		if err := tj.open(t, Options{}).Rekey([]string{"two@example.com", "me@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	want := "Rekeyed journal: 1 files re-encrypted to two@example.com, me@example.com\n" +
+		"Kept sub/.gpgid: 2 files re-encrypted to the recipients it lists\n"
+	if out != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", out, want)
+	}
+	if got, want := tj.read(t, "sub/.gpgid"), "me@example.com\n"; got != want {
+		t.Errorf("sub/.gpgid holds %q, want it unchanged", got)
+	}
+	for name, want := range map[string][]string{
+		"a.txt.gpg":     {defaultFakeKeys[1].keyID(), defaultFakeKeys[0].keyID()},
+		"sub/b.txt.gpg": {defaultFakeKeys[0].keyID()},
+		"sub/c.txt.gpg": {defaultFakeKeys[0].keyID()},
+	} {
+		if _, recipients := tj.fake.decrypt(t, tj.path(name)); !reflect.DeepEqual(recipients, want) {
+			t.Errorf("%s is encrypted to %v, want %v", name, recipients, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package journal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Results collects the outcome of an operation on each file of a journal,
+// such as decrypting it. It is safe for concurrent use by the workers
+// processing the files.
+type Results struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// Record notes the outcome for path, a failure if err is set.
+func (r *Results) Record(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.errs == nil {
+		r.errs = make(map[string]error)
+	}
+	r.errs[path] = err
+}
+
+// Err returns the error recorded for path, or nil if it succeeded or has not
+// been recorded.
+func (r *Results) Err(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.errs[path]
+}
+
+// Failed returns the paths recorded as failures, sorted.
+func (r *Results) Failed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var failed []string
+	for path, err := range r.errs {
+		if err != nil {
+			failed = append(failed, path)
+		}
+	}
+	sort.Strings(failed)
+
+	return failed
+}
+
+// Summary describes how many of the recorded paths failed, and which.
+func (r *Results) Summary() string {
+	failed := r.Failed()
+
+	r.mu.Lock()
+	total := len(r.errs)
+	r.mu.Unlock()
+
+	if len(failed) == 0 {
+		return fmt.Sprintf("none of %d files failed", total)
+	}
+	return fmt.Sprintf("%d of %d files failed (%s)", len(failed), total, strings.Join(failed, ", "))
+}
@@ -0,0 +1,94 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResults(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name    string
+		record  map[string]error
+		failed  []string
+		summary string
+	}{
+		{
+			name:    "nothing recorded",
+			summary: "none of 0 files failed",
+		},
+		{
+			name:    "all succeeded",
+			record:  map[string]error{"a.txt.gpg": nil, "b.txt.gpg": nil},
+			summary: "none of 2 files failed",
+		},
+		{
+			name:    "failures sorted",
+			record:  map[string]error{"c.txt.gpg": errFailed, "a.txt.gpg": errFailed, "b.txt.gpg": nil},
+			failed:  []string{"a.txt.gpg", "c.txt.gpg"},
+			summary: "2 of 3 files failed (a.txt.gpg, c.txt.gpg)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := &Results{}
+			for path, err := range tt.record {
+				results.Record(path, err)
+			}
+			for path, err := range tt.record {
+				if got := results.Err(path); got != err {
+					t.Errorf("Err(%s) = %v, want %v", path, got, err)
+				}
+			}
+			if err := results.Err("unrecorded.txt.gpg"); err != nil {
+				t.Errorf("Err of an unrecorded path = %v, want nil", err)
+			}
+			if got := results.Failed(); !reflect.DeepEqual(got, tt.failed) {
+				t.Errorf("Failed() = %v, want %v", got, tt.failed)
+			}
+			if got := results.Summary(); got != tt.summary {
+				t.Errorf("Summary() = %q, want %q", got, tt.summary)
+			}
+		})
+	}
+}
+
+func TestResultsRecordedAgain(t *testing.T) {
+	results := &Results{}
+	results.Record("a.txt.gpg", errors.New("failed"))
+	results.Record("a.txt.gpg", nil)
+
+	if got := results.Summary(); got != "none of 1 files failed" {
+		t.Errorf("Summary() = %q, want the later outcome only", got)
+	}
+}
+
+func TestResultsConcurrentUse(t *testing.T) {
+	results := &Results{}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var err error
+				if i%10 == 0 {
+					err = errors.New("failed")
+				}
+				results.Record(fmt.Sprintf("%d/%03d.txt.gpg", w, i), err)
+				results.Failed()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := results.Summary(); !strings.HasPrefix(got, "80 of 800 files failed") {
+		t.Errorf("Summary() = %q, want 80 of 800 files failed", got)
+	}
+}